@@ -3,7 +3,6 @@ package main
 import (
 	"fmt"
 	"image"
-	"image/png"
 	"os"
 	"sync"
 )
@@ -11,67 +10,244 @@ import (
 func aggregateData(request AggregateDataRequest) (MapAggregationResponse, error) {
 	var response MapAggregationResponse
 
-	overlayImg, overlayLatLongBounds, err := getOverlayData()
+	cells, err := scoreAggregationCells(request)
+	if err != nil {
+		return response, err
+	}
+
+	values := cells.Combine(cells.Weights())
+
+	aggregateData := []LatLongValue{}
+	for i, cell := range cells.positions {
+		if values[i] > 0 {
+			lat, long := getLatLong(cell.X, cell.Y, cells.gapX, cells.gapY, cells.overlayLatLongBounds)
+
+			aggregateData = append(aggregateData, LatLongValue{lat, long, values[i]})
+		}
+	}
+
+	candidates, err := findCandidateAreas(cells.request, cells.CandidateCells(values), cells.allResults, cells.gapX, cells.gapY, cells.overlayLatLongBounds)
 	if err != nil {
 		return response, err
 	}
 
+	return MapAggregationResponse{
+		AggregateData:        aggregateData,
+		UnknownData:          cells.unknownData,
+		FailedConstraintData: cells.failedConstraintData,
+		Candidates:           candidates,
+		ComponentsData:       cells.allResults,
+		GapY:                 cells.gapY,
+		GapX:                 cells.gapX,
+	}, nil
+}
+
+// AggregationCells holds every dataset's scores at each sampled cell that can
+// be aggregated, so they can be combined under different weights
+type AggregationCells struct {
+	request              AggregateDataRequest
+	owaWeights           []float64
+	allResults           []TaggedImageData
+	overlayLatLongBounds OverlayBounds
+	gapX, gapY           float64
+	positions            []Position
+	scores               [][]cellScore
+	// cells flagged as failing a constraint are kept in the aggregate data but can't be candidates
+	meetsAllConstraints  []bool
+	unknownData          []LatLong
+	failedConstraintData []LatLong
+}
+
+func scoreAggregationCells(request AggregateDataRequest) (AggregationCells, error) {
+	cells := AggregationCells{unknownData: []LatLong{}, failedConstraintData: []LatLong{}}
+
+	overlayImg, overlayLatLongBounds, err := getOverlayData()
+	if err != nil {
+		return cells, err
+	}
+
+	if err := normalizeAggregateDataRequest(&request); err != nil {
+		return cells, err
+	}
+
 	numPixels := overlayImg.Bounds().Max.Y * overlayImg.Bounds().Max.X
 	maxAllowedSamples := 200_000
 	numSamples := request.SamplingRate * request.SamplingRate
 	if numPixels/numSamples > maxAllowedSamples {
-		return response, fmt.Errorf("requested sampling rate too low and would generate %d samples, exceeding the maximum allowed of %d, please specify higher value", numPixels/numSamples, maxAllowedSamples)
+		return cells, fmt.Errorf("requested sampling rate too low and would generate %d samples, exceeding the maximum allowed of %d, please specify higher value", numPixels/numSamples, maxAllowedSamples)
 	}
 
 	validTags, err := filterTags(request.Tags)
 	if err != nil {
-		return response, err
+		return cells, err
 	}
 
-	allResults, err := computeAllFileValues(validTags, request.SamplingRate, overlayImg)
+	owaWeights, err := owaRankWeights(request, len(validTags))
 	if err != nil {
-		return response, err
+		return cells, err
+	}
+
+	allResults, err := computeAllFileValues(validTags, request.SamplingRate, request.NoDataPolicy, overlayImg)
+	if err != nil {
+		return cells, err
 	}
 
 	width, height := -1, -1
 	for _, result := range allResults {
 		if height != -1 && len(result.Data) != height {
-			return response, fmt.Errorf("heights do not match for all images")
+			return cells, fmt.Errorf("heights do not match for all images")
 		}
 		height = len(result.Data)
 
 		for _, row := range result.Data {
 			if width != -1 && len(row) != width {
-				return response, fmt.Errorf("widths do not match for all images")
+				return cells, fmt.Errorf("widths do not match for all images")
 			}
 			width = len(row)
 		}
 	}
 
-	gapX, gapY := getOverlayLatLongGaps(width, height, overlayLatLongBounds)
+	cells.request = request
+	cells.owaWeights = owaWeights
+	cells.allResults = allResults
+	cells.overlayLatLongBounds = overlayLatLongBounds
+	cells.gapX, cells.gapY = getOverlayLatLongGaps(width, height, overlayLatLongBounds)
+
+	cellMask := sampleOverlayMask(overlayImg, request.SamplingRate, width, height)
 
-	aggregateData := []LatLongValue{}
 	for y := range height {
 		for x := range width {
-			value := 0.0
-			for _, result := range allResults {
-				value += result.Data[y][x]
+			if !cellMask[y][x] {
+				continue
+			}
+
+			scores, isKnown := cellScores(allResults, x, y, request.NoDataPolicy)
+			if !isKnown {
+				lat, long := getLatLong(x, y, cells.gapX, cells.gapY, overlayLatLongBounds)
+
+				cells.unknownData = append(cells.unknownData, LatLong{Lat: lat, Long: long})
+				continue
 			}
 
-			if value > 0 {
-				lat, long := getLatLong(x, y, gapX, gapY, overlayLatLongBounds)
+			meetsCellConstraints := meetsConstraints(allResults, x, y, request.NoDataPolicy)
+			if !meetsCellConstraints {
+				lat, long := getLatLong(x, y, cells.gapX, cells.gapY, overlayLatLongBounds)
 
-				aggregateData = append(aggregateData, LatLongValue{lat, long, value})
+				cells.failedConstraintData = append(cells.failedConstraintData, LatLong{Lat: lat, Long: long})
+				if request.ConstraintMode == "exclude" {
+					continue
+				}
 			}
+
+			cells.positions = append(cells.positions, Position{X: x, Y: y})
+			cells.scores = append(cells.scores, scores)
+			cells.meetsAllConstraints = append(cells.meetsAllConstraints, meetsCellConstraints)
 		}
 	}
 
-	return MapAggregationResponse{
-		AggregateData:  aggregateData,
-		ComponentsData: allResults,
-		GapY:           gapY,
-		GapX:           gapX,
-	}, nil
+	return cells, nil
+}
+
+// Weights gives each dataset's share of the total weight, indexed like the datasets
+func (a AggregationCells) Weights() []float64 {
+	weights := make([]float64, len(a.allResults))
+	for i, result := range a.allResults {
+		weights[i] = result.weight
+	}
+
+	return weights
+}
+
+// Combine aggregates every cell under the given weights, indexed like the datasets
+func (a AggregationCells) Combine(weights []float64) []float64 {
+	// topsis compares each cell against the best and worst seen anywhere, so every cell is scored before combining
+	aggregator := newCellAggregator(a.request.Operator, a.owaWeights, weights, a.scores)
+
+	values := make([]float64, len(a.scores))
+	for i, scores := range a.scores {
+		values[i] = aggregator.Combine(scores)
+	}
+
+	return values
+}
+
+// CandidateCells pairs the cells that may be candidate areas with their values
+func (a AggregationCells) CandidateCells(values []float64) []PositionValue {
+	candidateCells := []PositionValue{}
+	for i, cell := range a.positions {
+		if a.meetsAllConstraints[i] {
+			candidateCells = append(candidateCells, PositionValue{Position: cell, Value: values[i]})
+		}
+	}
+
+	return candidateCells
+}
+
+// normalizeAggregateDataRequest validates the request's options and fills in their defaults
+func normalizeAggregateDataRequest(request *AggregateDataRequest) error {
+	if request.SamplingRate < 1 {
+		return fmt.Errorf("sampling rate must be at least 1")
+	}
+
+	switch request.NoDataPolicy {
+	case "":
+		request.NoDataPolicy = "worst"
+	case "worst", "neutral", "exclude", "unknown":
+	default:
+		return fmt.Errorf("unknown no data policy %s, expected worst, neutral, exclude or unknown", request.NoDataPolicy)
+	}
+
+	switch request.ConstraintMode {
+	case "":
+		request.ConstraintMode = "exclude"
+	case "exclude", "flag":
+	default:
+		return fmt.Errorf("unknown constraint mode %s, expected exclude or flag", request.ConstraintMode)
+	}
+
+	for _, tag := range request.Tags {
+		if tag.MinScore != nil && tag.MaxScore != nil && *tag.MinScore > *tag.MaxScore {
+			return fmt.Errorf("min score %f of tag %s exceeds its max score %f", *tag.MinScore, tag.Tag, *tag.MaxScore)
+		}
+	}
+
+	return validateAggregationOperator(request.Operator)
+}
+
+// sampleOverlayMask tells which sampled cells have any pixel within the overlay
+func sampleOverlayMask(overlayImg image.Image, samplingRate, width, height int) [][]bool {
+	mask := make([][]bool, height)
+	for iy := range height {
+		mask[iy] = make([]bool, width)
+		for ix := range width {
+			for off := range samplingRate * samplingRate {
+				if isWithinOverlay(overlayImg, ix*samplingRate+off%samplingRate, iy*samplingRate+off/samplingRate) {
+					mask[iy][ix] = true
+					break
+				}
+			}
+		}
+	}
+
+	return mask
+}
+
+// meetsConstraints tells whether every dataset's score at a cell is within its
+// tag's range. Cells without data are scored by the no data policy, and can't
+// fail a constraint when the policy gives them no score.
+func meetsConstraints(allResults []TaggedImageData, x, y int, noDataPolicy string) bool {
+	for _, result := range allResults {
+		if result.NoData != nil && result.NoData[y][x] && (noDataPolicy == "exclude" || noDataPolicy == "unknown") {
+			continue
+		}
+
+		score := result.scores[y][x]
+		if (result.minScore != nil && score < *result.minScore) || (result.maxScore != nil && score > *result.maxScore) {
+			return false
+		}
+	}
+
+	return true
 }
 
 func filterTags(tags []AggregateDataTagInfo) ([]AggregateDataTagInfo, error) {
@@ -93,7 +269,7 @@ func filterTags(tags []AggregateDataTagInfo) ([]AggregateDataTagInfo, error) {
 	return validTags, nil
 }
 
-func computeAllFileValues(validTags []AggregateDataTagInfo, samplingRate int, overlayImg image.Image) ([]TaggedImageData, error) {
+func computeAllFileValues(validTags []AggregateDataTagInfo, samplingRate int, noDataPolicy string, overlayImg image.Image) ([]TaggedImageData, error) {
 	totalWeight := 0.0
 	for _, t := range validTags {
 		totalWeight += t.Weight
@@ -104,18 +280,17 @@ func computeAllFileValues(validTags []AggregateDataTagInfo, samplingRate int, ov
 
 	var wg sync.WaitGroup
 	for _, tagInfo := range validTags {
-		fullPath := "./database/maps/" + tagInfo.Tag + ".png"
-
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			result, err := computeFileValues(fullPath, tagInfo.Weight/totalWeight, tagInfo.IsHighGood, samplingRate, overlayImg)
+			weight := tagInfo.Weight / totalWeight
+			scores, noData, err := computeFileValues(tagInfo.Tag, tagInfo.IsHighGood, samplingRate, overlayImg)
 			if err != nil {
 				errorsChan <- err
 				return
 			}
 
-			resultsChan <- TaggedImageData{Tag: tagInfo.Tag, Data: result}
+			resultsChan <- weightFileValues(tagInfo, scores, noData, weight, noDataPolicy)
 		}()
 	}
 
@@ -135,18 +310,46 @@ func computeAllFileValues(validTags []AggregateDataTagInfo, samplingRate int, ov
 	return allResults, nil
 }
 
-func computeFileValues(filename string, weight float64, isHighGood bool, samplingRate int, overlayImg image.Image) ([][]float64, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return nil, err
+// weightFileValues weights a dataset's scores, scoring cells without data as 0
+// under the worst policy and 0.5 under the neutral one. Under the others they
+// are left at 0 for the aggregation to handle.
+func weightFileValues(tagInfo AggregateDataTagInfo, scores [][]float64, noData [][]bool, weight float64, noDataPolicy string) TaggedImageData {
+	result := TaggedImageData{Tag: tagInfo.Tag, scores: scores, weight: weight, minScore: tagInfo.MinScore, maxScore: tagInfo.MaxScore}
+
+	hasNoData := false
+	data := make([][]float64, len(scores))
+	for y, row := range scores {
+		data[y] = make([]float64, len(row))
+		for x, score := range row {
+			if noData[y][x] {
+				hasNoData = true
+
+				score = 0
+				if noDataPolicy == "neutral" {
+					score = 0.5
+				}
+				scores[y][x] = score
+			}
+
+			data[y][x] = score * weight
+		}
 	}
 
-	pngFile, err := png.Decode(file)
-	if err != nil {
-		return nil, err
+	result.Data = data
+	if hasNoData {
+		result.NoData = noData
 	}
 
-	img := decodeToRGBA(pngFile)
+	return result
+}
+
+// computeFileValues averages a dataset's scores over each sampled cell, along
+// with which cells lie within the overlay but have no data at any pixel
+func computeFileValues(tag string, isHighGood bool, samplingRate int, overlayImg image.Image) ([][]float64, [][]bool, error) {
+	img, err := getStoredMap(tag)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	bounds := img.Bounds()
 
@@ -154,6 +357,7 @@ func computeFileValues(filename string, weight float64, isHighGood bool, samplin
 	xSamples := bounds.Max.X / samplingRate
 
 	result := make([][]float64, ySamples)
+	noData := make([][]bool, ySamples)
 
 	var wg sync.WaitGroup
 	for iy := range ySamples {
@@ -161,21 +365,30 @@ func computeFileValues(filename string, weight float64, isHighGood bool, samplin
 		go func() {
 			defer wg.Done()
 			row := []float64{}
+			noDataRow := make([]bool, xSamples)
 			for ix := range xSamples {
 				topLeftX, topLeftY := ix*samplingRate, iy*samplingRate
 
 				sumValue := 0
 				numRelevant := 0
+				numNoData := 0
 				for offY := range samplingRate {
 					for offX := range samplingRate {
 						if isWithinOverlay(overlayImg, topLeftX+offX, topLeftY+offY) {
-							_, g, _, _ := getRgba(img, topLeftX+offX, topLeftY+offY)
+							r, g, b, a := getRgba(img, topLeftX+offX, topLeftY+offY)
+							if isNoDataPixel(r, g, b, a) {
+								numNoData++
+								continue
+							}
+
 							sumValue += int(g)
 							numRelevant++
 						}
 					}
 				}
 
+				noDataRow[ix] = numRelevant == 0 && numNoData > 0
+
 				avgValue := 0.0
 				if numRelevant > 0 {
 					avgValue = float64(sumValue) / float64(numRelevant)
@@ -184,13 +397,14 @@ func computeFileValues(filename string, weight float64, isHighGood bool, samplin
 				if !isHighGood {
 					value = 1 - value
 				}
-				row = append(row, value*weight)
+				row = append(row, value)
 			}
 			result[iy] = row
+			noData[iy] = noDataRow
 		}()
 	}
 
 	wg.Wait()
 
-	return result, nil
+	return result, noData, nil
 }
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestAggregateDataRejectsSamplingRatesBelowOne(t *testing.T) {
+	chdirTemp(t)
+
+	writeOverlayFixture(t, 2, 2, func(x, y int) bool { return true })
+	writeMapFixture(t, "rateA", 2, 2, func(x, y int) color.NRGBA { return valuePixel(100) })
+
+	for _, samplingRate := range []int{0, -1} {
+		request := AggregateDataRequest{
+			Tags:         []AggregateDataTagInfo{{Tag: "rateA", IsHighGood: true, Weight: 1}},
+			SamplingRate: samplingRate,
+		}
+
+		if _, err := aggregateData(request); err == nil || !strings.Contains(err.Error(), "sampling rate must be at least 1") {
+			t.Errorf("sampling rate %d: error = %v, want the sampling rate rejected", samplingRate, err)
+		}
+	}
+}
+
+// cellValuesByX maps each aggregated cell of a one row overlay to its value by column
+func cellValuesByX(data []LatLongValue) map[int]float64 {
+	values := map[int]float64{}
+	for _, cell := range data {
+		values[int(math.Floor(cell[1]))] = cell[2]
+	}
+
+	return values
+}
+
+func TestAggregateDataNoDataPolicies(t *testing.T) {
+	chdirTemp(t)
+
+	// the first column has data only for policyA, the second for both
+	writeOverlayFixture(t, 2, 1, func(x, y int) bool { return true })
+	writeMapFixture(t, "policyA", 2, 1, func(x, y int) color.NRGBA { return valuePixel([]uint8{204, 102}[x]) })
+	writeMapFixture(t, "policyB", 2, 1, func(x, y int) color.NRGBA {
+		if x == 0 {
+			return noDataPixel
+		}
+		return valuePixel(51)
+	})
+
+	tests := []struct {
+		policy      string
+		want        map[int]float64
+		wantUnknown int
+	}{
+		{"", map[int]float64{0: 0.4, 1: 0.3}, 0},
+		{"worst", map[int]float64{0: 0.4, 1: 0.3}, 0},
+		{"neutral", map[int]float64{0: 0.65, 1: 0.3}, 0},
+		{"exclude", map[int]float64{0: 0.8, 1: 0.3}, 0},
+		{"unknown", map[int]float64{1: 0.3}, 1},
+	}
+
+	for _, test := range tests {
+		request := AggregateDataRequest{
+			Tags: []AggregateDataTagInfo{
+				{Tag: "policyA", IsHighGood: true, Weight: 1},
+				{Tag: "policyB", IsHighGood: true, Weight: 1},
+			},
+			SamplingRate: 1,
+			NoDataPolicy: test.policy,
+		}
+
+		response, err := aggregateData(request)
+		if err != nil {
+			t.Errorf("%q: %v", test.policy, err)
+			continue
+		}
+
+		got := cellValuesByX(response.AggregateData)
+		if len(got) != len(test.want) {
+			t.Errorf("%q: got %v, want %v", test.policy, got, test.want)
+			continue
+		}
+		for x, want := range test.want {
+			if math.Abs(got[x]-want) > 1e-9 {
+				t.Errorf("%q: column %d = %f, want %f", test.policy, x, got[x], want)
+			}
+		}
+
+		if len(response.UnknownData) != test.wantUnknown {
+			t.Errorf("%q: %d unknown cells, want %d", test.policy, len(response.UnknownData), test.wantUnknown)
+		} else if test.wantUnknown > 0 && math.Floor(response.UnknownData[0].Long) != 0 {
+			t.Errorf("%q: unknown cell at %v, want the first column", test.policy, response.UnknownData[0])
+		}
+	}
+}
+
+func TestAggregateDataRejectsUnknownNoDataPolicies(t *testing.T) {
+	chdirTemp(t)
+
+	writeOverlayFixture(t, 1, 1, func(x, y int) bool { return true })
+	writeMapFixture(t, "policyA", 1, 1, func(x, y int) color.NRGBA { return valuePixel(100) })
+
+	request := AggregateDataRequest{
+		Tags:         []AggregateDataTagInfo{{Tag: "policyA", IsHighGood: true, Weight: 1}},
+		SamplingRate: 1,
+		NoDataPolicy: "ignore",
+	}
+
+	if _, err := aggregateData(request); err == nil || !strings.Contains(err.Error(), "unknown no data policy") {
+		t.Errorf("error = %v, want the no data policy rejected", err)
+	}
+}
+
+func TestAggregateDataConstraintModes(t *testing.T) {
+	chdirTemp(t)
+
+	// only the first column meets the min score
+	writeOverlayFixture(t, 2, 1, func(x, y int) bool { return true })
+	writeMapFixture(t, "constraintA", 2, 1, func(x, y int) color.NRGBA { return valuePixel([]uint8{204, 102}[x]) })
+
+	tests := []struct {
+		mode string
+		want map[int]float64
+	}{
+		{"", map[int]float64{0: 0.8}},
+		{"exclude", map[int]float64{0: 0.8}},
+		{"flag", map[int]float64{0: 0.8, 1: 0.4}},
+	}
+
+	for _, test := range tests {
+		request := AggregateDataRequest{
+			Tags:           []AggregateDataTagInfo{{Tag: "constraintA", IsHighGood: true, Weight: 1, MinScore: ptr(0.5)}},
+			SamplingRate:   1,
+			ConstraintMode: test.mode,
+			TopN:           2,
+			// a single cell apart, so both columns would be candidates if allowed
+			MinSeparationMiles: 1,
+		}
+
+		response, err := aggregateData(request)
+		if err != nil {
+			t.Errorf("%q: %v", test.mode, err)
+			continue
+		}
+
+		got := cellValuesByX(response.AggregateData)
+		if len(got) != len(test.want) {
+			t.Errorf("%q: got %v, want %v", test.mode, got, test.want)
+			continue
+		}
+		for x, want := range test.want {
+			if math.Abs(got[x]-want) > 1e-9 {
+				t.Errorf("%q: column %d = %f, want %f", test.mode, x, got[x], want)
+			}
+		}
+
+		// the failing cell is reported either way, but never offered as a candidate
+		if len(response.FailedConstraintData) != 1 || math.Floor(response.FailedConstraintData[0].Long) != 1 {
+			t.Errorf("%q: failed constraint data %v, want the second column", test.mode, response.FailedConstraintData)
+		}
+		if len(response.Candidates) != 1 || math.Floor(response.Candidates[0].Peak.Long) != 0 {
+			t.Errorf("%q: candidates %v, want only the first column", test.mode, response.Candidates)
+		}
+	}
+}
+
+func TestAggregateDataRejectsInvalidConstraints(t *testing.T) {
+	chdirTemp(t)
+
+	writeOverlayFixture(t, 1, 1, func(x, y int) bool { return true })
+	writeMapFixture(t, "constraintA", 1, 1, func(x, y int) color.NRGBA { return valuePixel(100) })
+
+	tests := []struct {
+		name    string
+		mode    string
+		tagInfo AggregateDataTagInfo
+		wantErr string
+	}{
+		{"unknown mode", "drop", AggregateDataTagInfo{Tag: "constraintA", IsHighGood: true, Weight: 1}, "unknown constraint mode"},
+		{"min above max", "", AggregateDataTagInfo{Tag: "constraintA", IsHighGood: true, Weight: 1, MinScore: ptr(0.6), MaxScore: ptr(0.4)}, "exceeds its max score"},
+	}
+
+	for _, test := range tests {
+		request := AggregateDataRequest{Tags: []AggregateDataTagInfo{test.tagInfo}, SamplingRate: 1, ConstraintMode: test.mode}
+
+		if _, err := aggregateData(request); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: error = %v, want one containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+type cellScore struct {
+	tagI  int
+	score float64
+}
+
+// cellScores collects the score of every dataset at a cell,
+// applying the no data policy to datasets without data there. The cell is
+// unknown when the policy is unknown and any dataset lacks data, or when the
+// policy is exclude and every dataset does.
+func cellScores(allResults []TaggedImageData, x, y int, noDataPolicy string) ([]cellScore, bool) {
+	scores := []cellScore{}
+	hasNoData := false
+	for i, result := range allResults {
+		if result.NoData != nil && result.NoData[y][x] {
+			hasNoData = true
+			if noDataPolicy == "exclude" || noDataPolicy == "unknown" {
+				continue
+			}
+		}
+
+		scores = append(scores, cellScore{tagI: i, score: result.scores[y][x]})
+	}
+
+	if hasNoData && (noDataPolicy == "unknown" || len(scores) == 0) {
+		return nil, false
+	}
+
+	return scores, true
+}
+
+func validateAggregationOperator(operator string) error {
+	switch operator {
+	case "", "arithmeticMean", "geometricMean", "minimum", "owa", "topsis":
+		return nil
+	default:
+		return fmt.Errorf("unknown aggregation operator %s, expected arithmeticMean, geometricMean, minimum, owa or topsis", operator)
+	}
+}
+
+// owaRankWeights gives, for owa, the rank weights normalized to sum to 1. There
+// must be one per dataset actually aggregated, so tags without a stored map
+// have to be filtered out before this is called.
+func owaRankWeights(request AggregateDataRequest, numTags int) ([]float64, error) {
+	if request.Operator != "owa" {
+		return nil, nil
+	}
+
+	if len(request.OwaWeights) != numTags {
+		return nil, fmt.Errorf("owa needs one weight per tag with a stored map, got %d weights for %d tags", len(request.OwaWeights), numTags)
+	}
+
+	total := 0.0
+	for _, weight := range request.OwaWeights {
+		if weight < 0 {
+			return nil, fmt.Errorf("owa weights must not be negative")
+		}
+		total += weight
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("owa weights must not all be 0")
+	}
+
+	owaWeights := make([]float64, len(request.OwaWeights))
+	for i, weight := range request.OwaWeights {
+		owaWeights[i] = weight / total
+	}
+
+	return owaWeights, nil
+}
+
+// CellAggregator combines the scores of every dataset at a cell into one value
+type CellAggregator struct {
+	operator string
+	// each dataset's share of the total weight
+	weights    []float64
+	owaWeights []float64
+	// for topsis, each tag's vector normalization and its best and worst weighted normalized score over all cells
+	topsisNorms, idealBest, idealWorst []float64
+}
+
+func newCellAggregator(operator string, owaWeights, weights []float64, allCellScores [][]cellScore) CellAggregator {
+	aggregator := CellAggregator{operator: operator, weights: weights, owaWeights: owaWeights}
+	numTags := len(weights)
+	if operator != "topsis" {
+		return aggregator
+	}
+
+	aggregator.topsisNorms = make([]float64, numTags)
+	for _, scores := range allCellScores {
+		for _, s := range scores {
+			aggregator.topsisNorms[s.tagI] += s.score * s.score
+		}
+	}
+	for i := range aggregator.topsisNorms {
+		aggregator.topsisNorms[i] = math.Sqrt(aggregator.topsisNorms[i])
+	}
+
+	aggregator.idealBest = make([]float64, numTags)
+	aggregator.idealWorst = make([]float64, numTags)
+	for i := range numTags {
+		aggregator.idealBest[i] = math.Inf(-1)
+		aggregator.idealWorst[i] = math.Inf(1)
+	}
+	for _, scores := range allCellScores {
+		for _, s := range scores {
+			v := aggregator.topsisValue(s)
+			aggregator.idealBest[s.tagI] = math.Max(aggregator.idealBest[s.tagI], v)
+			aggregator.idealWorst[s.tagI] = math.Min(aggregator.idealWorst[s.tagI], v)
+		}
+	}
+
+	return aggregator
+}
+
+func (a CellAggregator) topsisValue(s cellScore) float64 {
+	if a.topsisNorms[s.tagI] == 0 {
+		return 0
+	}
+
+	return a.weights[s.tagI] * s.score / a.topsisNorms[s.tagI]
+}
+
+// Combine aggregates a cell's scores. Weights are renormalized over the scores
+// present, so datasets excluded for lacking data don't drag the value down.
+func (a CellAggregator) Combine(scores []cellScore) float64 {
+	if len(scores) == 0 {
+		return 0
+	}
+
+	totalWeight := 0.0
+	for _, s := range scores {
+		totalWeight += a.weights[s.tagI]
+	}
+
+	switch a.operator {
+	case "geometricMean":
+		if totalWeight == 0 {
+			return 0
+		}
+
+		logSum := 0.0
+		for _, s := range scores {
+			if a.weights[s.tagI] == 0 {
+				continue
+			}
+			// a zero score can't be compensated for by any other
+			if s.score <= 0 {
+				return 0
+			}
+			logSum += a.weights[s.tagI] * math.Log(s.score)
+		}
+
+		return math.Exp(logSum / totalWeight)
+	case "minimum":
+		// the weakest score decides regardless of how much weight its dataset has, though datasets weighted 0 are left out
+		value := math.Inf(1)
+		for _, s := range scores {
+			if a.weights[s.tagI] > 0 {
+				value = math.Min(value, s.score)
+			}
+		}
+
+		if math.IsInf(value, 1) {
+			return 0
+		}
+
+		return value
+	case "owa":
+		sorted := make([]float64, len(scores))
+		for i, s := range scores {
+			sorted[i] = s.score
+		}
+		slices.Sort(sorted)
+		slices.Reverse(sorted)
+
+		// with scores excluded for lacking data, only the leading rank weights apply
+		value, rankWeightSum := 0.0, 0.0
+		for i, score := range sorted {
+			value += a.owaWeights[i] * score
+			rankWeightSum += a.owaWeights[i]
+		}
+
+		if rankWeightSum == 0 {
+			return 0
+		}
+
+		return value / rankWeightSum
+	case "topsis":
+		distBest, distWorst := 0.0, 0.0
+		for _, s := range scores {
+			v := a.topsisValue(s)
+			distBest += (v - a.idealBest[s.tagI]) * (v - a.idealBest[s.tagI])
+			distWorst += (v - a.idealWorst[s.tagI]) * (v - a.idealWorst[s.tagI])
+		}
+		distBest, distWorst = math.Sqrt(distBest), math.Sqrt(distWorst)
+
+		// every cell is equally close to the ideal
+		if distBest+distWorst == 0 {
+			return 1
+		}
+
+		return distWorst / (distBest + distWorst)
+	default:
+		if totalWeight == 0 {
+			return 0
+		}
+
+		value := 0.0
+		for _, s := range scores {
+			value += a.weights[s.tagI] * s.score
+		}
+
+		return value / totalWeight
+	}
+}
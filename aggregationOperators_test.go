@@ -0,0 +1,106 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestCellAggregatorCombine(t *testing.T) {
+	scores := func(vals ...float64) []cellScore {
+		cells := []cellScore{}
+		for i, val := range vals {
+			if !math.IsNaN(val) {
+				cells = append(cells, cellScore{tagI: i, score: val})
+			}
+		}
+		return cells
+	}
+	missing := math.NaN()
+
+	tests := []struct {
+		name       string
+		operator   string
+		weights    []float64
+		owaWeights []float64
+		cell       []cellScore
+		want       float64
+	}{
+		{"arithmetic mean", "", []float64{0.75, 0.25}, nil, scores(1, 0), 0.75},
+		{"arithmetic mean renormalizes over present scores", "arithmeticMean", []float64{0.5, 0.25, 0.25}, nil, scores(missing, 1, 0.5), 0.75},
+		{"geometric mean", "geometricMean", []float64{0.5, 0.5}, nil, scores(0.25, 1), 0.5},
+		{"geometric mean is zeroed by a zero score", "geometricMean", []float64{0.5, 0.5}, nil, scores(0, 1), 0},
+		{"geometric mean skips zero weights", "geometricMean", []float64{0, 1}, nil, scores(0, 0.4), 0.4},
+		{"minimum", "minimum", []float64{0.9, 0.1}, nil, scores(0.8, 0.3), 0.3},
+		{"minimum skips zero weights", "minimum", []float64{0, 1}, nil, scores(0.3, 0.8), 0.8},
+		{"owa orders scores before weighting", "owa", []float64{1, 1, 1}, []float64{0.5, 0.3, 0.2}, scores(0.2, 0.9, 0.5), 0.64},
+		{"owa with a score excluded uses the leading rank weights", "owa", []float64{1, 1, 1}, []float64{0.5, 0.3, 0.2}, scores(missing, 0.9, 0.5), 0.75},
+	}
+
+	for _, test := range tests {
+		aggregator := newCellAggregator(test.operator, test.owaWeights, test.weights, [][]cellScore{test.cell})
+		if got := aggregator.Combine(test.cell); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: Combine = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCellAggregatorTopsis(t *testing.T) {
+	allCellScores := [][]cellScore{
+		{{0, 1}, {1, 0}},
+		{{0, 0}, {1, 1}},
+		{{0, 1}, {1, 1}},
+		{{0, 0}, {1, 0}},
+		{{0, 0.5}, {1, 0.5}},
+	}
+	// the ideal cell scores 1, the anti-ideal 0 and the rest sit halfway between
+	want := []float64{0.5, 0.5, 1, 0, 0.5}
+
+	aggregator := newCellAggregator("topsis", nil, []float64{0.5, 0.5}, allCellScores)
+	for i, scores := range allCellScores {
+		if got := aggregator.Combine(scores); math.Abs(got-want[i]) > 1e-9 {
+			t.Errorf("cell %d: topsis = %v, want %v", i, got, want[i])
+		}
+	}
+
+	// every cell alike is equally close to the ideal
+	flat := [][]cellScore{{{0, 0.5}}, {{0, 0.5}}}
+	if got := newCellAggregator("topsis", nil, []float64{1}, flat).Combine(flat[0]); got != 1 {
+		t.Errorf("flat topsis = %v, want 1", got)
+	}
+}
+
+func TestOwaRankWeights(t *testing.T) {
+	tests := []struct {
+		name     string
+		operator string
+		weights  []float64
+		numTags  int
+		want     []float64
+		wantErr  bool
+	}{
+		{"not owa", "minimum", []float64{1}, 3, nil, false},
+		{"normalized", "owa", []float64{2, 1, 1}, 3, []float64{0.5, 0.25, 0.25}, false},
+		{"counted against the stored tags", "owa", []float64{2, 1, 1}, 2, nil, true},
+		{"negative", "owa", []float64{2, -1}, 2, nil, true},
+		{"all zero", "owa", []float64{0, 0}, 2, nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := owaRankWeights(AggregateDataRequest{Operator: test.operator, OwaWeights: test.weights}, test.numTags)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-test.want[i]) > 1e-9 {
+				t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
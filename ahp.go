@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+const (
+	// judgments are considered acceptably consistent below this ratio, following Saaty
+	maxConsistencyRatio          float64 = 0.1
+	ahpPowerIterations                   = 1000
+	ahpReciprocalTolerance               = 0.01
+	maxInconsistentPairsReported         = 3
+)
+
+// Saaty's random consistency index for matrices of 1 to 15 tags
+var ahpRandomIndices = []float64{0, 0, 0.58, 0.90, 1.12, 1.24, 1.32, 1.41, 1.45, 1.49, 1.51, 1.48, 1.56, 1.57, 1.59}
+
+type ahpPairDeviation struct {
+	i, j      int
+	deviation float64
+}
+
+// deriveAhpWeights takes the principal eigenvector of the pairwise comparison
+// matrix as the tags' weights, and checks how consistent the judgments are
+func deriveAhpWeights(request AhpWeightsRequest) (AhpWeightsResponse, error) {
+	response := AhpWeightsResponse{Warnings: []string{}}
+
+	n := len(request.Tags)
+	if n == 0 {
+		return response, fmt.Errorf("no tags to weigh")
+	}
+
+	seen := map[string]bool{}
+	for _, tag := range request.Tags {
+		if seen[tag.Tag] {
+			return response, fmt.Errorf("tag %s is compared more than once", tag.Tag)
+		}
+		seen[tag.Tag] = true
+	}
+
+	// weights for a tag without a stored map would be dropped from any aggregation, skewing the rest
+	validTags, err := filterTags(request.Tags)
+	if err != nil {
+		return response, err
+	}
+	if len(validTags) != n {
+		for _, tag := range request.Tags {
+			if !slices.ContainsFunc(validTags, func(valid AggregateDataTagInfo) bool { return valid.Tag == tag.Tag }) {
+				return response, fmt.Errorf("tag %s has no stored map", tag.Tag)
+			}
+		}
+	}
+
+	if len(request.Comparisons) != n {
+		return response, fmt.Errorf("comparison matrix has %d rows for %d tags", len(request.Comparisons), n)
+	}
+
+	matrix := make([][]float64, n)
+	for i, row := range request.Comparisons {
+		if len(row) != n {
+			return response, fmt.Errorf("comparison matrix row %d has %d entries for %d tags", i, len(row), n)
+		}
+		matrix[i] = slices.Clone(row)
+	}
+
+	outOfScale := false
+	diagonalOverridden := false
+	for i := range n {
+		// the diagonal may be left 0, anything but 1 is a mistaken judgment
+		if matrix[i][i] != 0 && matrix[i][i] != 1 {
+			diagonalOverridden = true
+		}
+		matrix[i][i] = 1
+		for j := i + 1; j < n; j++ {
+			if matrix[i][j] <= 0 {
+				return response, fmt.Errorf("comparison of %s to %s must be positive", request.Tags[i].Tag, request.Tags[j].Tag)
+			}
+
+			if matrix[j][i] == 0 {
+				matrix[j][i] = 1 / matrix[i][j]
+			} else if math.Abs(matrix[i][j]*matrix[j][i]-1) > ahpReciprocalTolerance {
+				return response, fmt.Errorf("comparisons of %s to %s and back are %f and %f, which aren't reciprocal", request.Tags[i].Tag, request.Tags[j].Tag, matrix[i][j], matrix[j][i])
+			}
+
+			if matrix[i][j] > 9 || matrix[i][j] < 1.0/9 {
+				outOfScale = true
+			}
+		}
+	}
+
+	if diagonalOverridden {
+		response.Warnings = append(response.Warnings, "diagonal entries other than 1 were replaced with 1, since a tag is always as important as itself")
+	}
+
+	if outOfScale {
+		response.Warnings = append(response.Warnings, "some comparisons are outside Saaty's 1/9 to 9 scale")
+	}
+
+	// power iteration converges on the principal eigenvector since the matrix is positive
+	weights := make([]float64, n)
+	for i := range weights {
+		weights[i] = 1 / float64(n)
+	}
+	for range ahpPowerIterations {
+		next := make([]float64, n)
+		total := 0.0
+		for i := range n {
+			for j := range n {
+				next[i] += matrix[i][j] * weights[j]
+			}
+			total += next[i]
+		}
+
+		change := 0.0
+		for i := range n {
+			next[i] /= total
+			change = math.Max(change, math.Abs(next[i]-weights[i]))
+		}
+
+		weights = next
+		if change < 1e-12 {
+			break
+		}
+	}
+
+	for i := range n {
+		row := 0.0
+		for j := range n {
+			row += matrix[i][j] * weights[j]
+		}
+		response.LambdaMax += row / weights[i] / float64(n)
+	}
+
+	if n > 2 {
+		response.ConsistencyIndex = (response.LambdaMax - float64(n)) / float64(n-1)
+		randomIndex := ahpRandomIndices[min(n, len(ahpRandomIndices))-1]
+		response.ConsistencyRatio = response.ConsistencyIndex / randomIndex
+	}
+
+	response.IsConsistent = response.ConsistencyRatio <= maxConsistencyRatio
+	if !response.IsConsistent {
+		response.Warnings = append(response.Warnings, fmt.Sprintf("consistency ratio %.3f exceeds %.1f, consider revising the judgments below", response.ConsistencyRatio, maxConsistencyRatio))
+
+		// the judgments furthest from the ratio of the derived weights are the likeliest to revise
+		deviations := []ahpPairDeviation{}
+		for i := range n {
+			for j := i + 1; j < n; j++ {
+				deviations = append(deviations, ahpPairDeviation{i: i, j: j, deviation: math.Abs(math.Log(matrix[i][j] * weights[j] / weights[i]))})
+			}
+		}
+		slices.SortFunc(deviations, func(a, b ahpPairDeviation) int { return compareDesc(a.deviation, b.deviation) })
+
+		for _, d := range deviations[:min(maxInconsistentPairsReported, len(deviations))] {
+			response.Warnings = append(response.Warnings, fmt.Sprintf(
+				"%s was judged %.2f times as important as %s, but the weights imply %.2f",
+				request.Tags[d.i].Tag, matrix[d.i][d.j], request.Tags[d.j].Tag, weights[d.i]/weights[d.j],
+			))
+		}
+	}
+
+	response.Tags = slices.Clone(request.Tags)
+	for i := range response.Tags {
+		response.Tags[i].Weight = weights[i]
+	}
+
+	return response, nil
+}
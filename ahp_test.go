@@ -0,0 +1,82 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestDeriveAhpWeights(t *testing.T) {
+	chdirTemp(t)
+
+	writeOverlayFixture(t, 1, 1, func(x, y int) bool { return true })
+	for _, tag := range []string{"ahpA", "ahpB", "ahpC"} {
+		writeMapFixture(t, tag, 1, 1, func(x, y int) color.NRGBA { return valuePixel(0) })
+	}
+
+	tags := func(names ...string) []AggregateDataTagInfo {
+		tagInfos := []AggregateDataTagInfo{}
+		for _, name := range names {
+			tagInfos = append(tagInfos, AggregateDataTagInfo{Tag: name})
+		}
+		return tagInfos
+	}
+
+	// the textbook example, with weights of about 0.637, 0.258 and 0.105 and a consistency ratio of 0.033
+	textbook := [][]float64{{1, 3, 5}, {1.0 / 3, 1, 3}, {1.0 / 5, 1.0 / 3, 1}}
+
+	tests := []struct {
+		name        string
+		tags        []AggregateDataTagInfo
+		comparisons [][]float64
+		wantWeights []float64
+		wantCR      float64
+		wantWarning string
+		wantErr     string
+	}{
+		{"textbook", tags("ahpA", "ahpB", "ahpC"), textbook, []float64{0.637, 0.258, 0.105}, 0.0332, "", ""},
+		{"reciprocals left as 0", tags("ahpA", "ahpB", "ahpC"), [][]float64{{1, 3, 5}, {0, 1, 3}, {0, 0, 1}}, []float64{0.637, 0.258, 0.105}, 0.0332, "", ""},
+		{"diagonal other than 1", tags("ahpA", "ahpB", "ahpC"), [][]float64{{2, 3, 5}, {0, 1, 3}, {0, 0, 1}}, []float64{0.637, 0.258, 0.105}, 0.0332, "diagonal", ""},
+		{"two tags are always consistent", tags("ahpA", "ahpB"), [][]float64{{1, 3}, {0, 1}}, []float64{0.75, 0.25}, 0, "", ""},
+		{"inconsistent", tags("ahpA", "ahpB", "ahpC"), [][]float64{{1, 9, 1.0 / 9}, {0, 1, 9}, {0, 0, 1}}, nil, 0, "consistency ratio", ""},
+		{"duplicate tag", tags("ahpA", "ahpA"), [][]float64{{1, 3}, {0, 1}}, nil, 0, "", "more than once"},
+		{"tag without a stored map", tags("ahpA", "unknown"), [][]float64{{1, 3}, {0, 1}}, nil, 0, "", "unknown has no stored map"},
+		{"not reciprocal", tags("ahpA", "ahpB"), [][]float64{{1, 3}, {3, 1}}, nil, 0, "", "reciprocal"},
+		{"not positive", tags("ahpA", "ahpB"), [][]float64{{1, -3}, {0, 1}}, nil, 0, "", "positive"},
+		{"ragged matrix", tags("ahpA", "ahpB"), [][]float64{{1, 3}, {0}}, nil, 0, "", "row 1"},
+	}
+
+	for _, test := range tests {
+		response, err := deriveAhpWeights(AhpWeightsRequest{Tags: test.tags, Comparisons: test.comparisons})
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error = %v, want one containing %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if test.wantWeights != nil {
+			for i, tag := range response.Tags {
+				if math.Abs(tag.Weight-test.wantWeights[i]) > 1e-3 {
+					t.Errorf("%s: weight of %s = %v, want %v", test.name, tag.Tag, tag.Weight, test.wantWeights[i])
+				}
+			}
+			if math.Abs(response.ConsistencyRatio-test.wantCR) > 1e-3 || !response.IsConsistent {
+				t.Errorf("%s: consistency ratio %v, consistent %v, want %v", test.name, response.ConsistencyRatio, response.IsConsistent, test.wantCR)
+			}
+		}
+
+		hasWarning := slices.ContainsFunc(response.Warnings, func(warning string) bool {
+			return test.wantWarning != "" && strings.Contains(warning, test.wantWarning)
+		})
+		if (test.wantWarning != "") != hasWarning {
+			t.Errorf("%s: warnings %q, want one containing %q", test.name, response.Warnings, test.wantWarning)
+		}
+	}
+}
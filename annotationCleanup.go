@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// fillUnmatchedPixels fills pixels within the mask that matched no legend
+// color, such as text, borders and roads drawn over the map, in a single pass.
+// The mask should only cover the submitted image, so areas it doesn't show
+// aren't given made up values.
+//
+// "nearest" gives each such pixel the value of the closest matched pixel,
+// propagating outward from every matched pixel at once, so thick annotations
+// are filled as readily as thin ones. "majority" gives it the most common value
+// among matched pixels in the surrounding square. Pixels further than radius
+// from any matched pixel are left unmatched, with a radius of 0 meaning no limit
+// for "nearest".
+func fillUnmatchedPixels(colorDataMatrix [][]ColorValue, overlayMask [][]bool, mode string, radius int) error {
+	switch mode {
+	case "", "none":
+		return nil
+	case "nearest":
+		fillNearestMatched(colorDataMatrix, overlayMask, radius)
+		return nil
+	case "majority":
+		if radius < 1 {
+			return fmt.Errorf("majority cleanup needs a radius of at least 1")
+		}
+		fillMajorityMatched(colorDataMatrix, overlayMask, radius)
+		return nil
+	default:
+		return fmt.Errorf("unknown cleanup mode %s, expected none, nearest or majority", mode)
+	}
+}
+
+func isUnmatched(colorDataMatrix [][]ColorValue, overlayMask [][]bool, x, y int) bool {
+	return overlayMask[y][x] && !colorDataMatrix[y][x].IsWithinOverlay
+}
+
+// fillNearestMatched propagates the position of the nearest matched pixel
+// breadth first, relaxing neighbours whenever a closer source is found, which
+// approximates an exact euclidean distance transform
+func fillNearestMatched(colorDataMatrix [][]ColorValue, overlayMask [][]bool, radius int) {
+	height := len(colorDataMatrix)
+	if height == 0 {
+		return
+	}
+	width := len(colorDataMatrix[0])
+
+	hasSource := make([][]bool, height)
+	sources := make([][]Position, height)
+	for y := range height {
+		hasSource[y] = make([]bool, width)
+		sources[y] = make([]Position, width)
+	}
+
+	queue := []Position{}
+	for y := range height {
+		for x := range width {
+			if colorDataMatrix[y][x].IsWithinOverlay {
+				hasSource[y][x] = true
+				sources[y][x] = Position{X: x, Y: y}
+				queue = append(queue, Position{X: x, Y: y})
+			}
+		}
+	}
+
+	maxDistSq := math.MaxInt
+	if radius > 0 {
+		maxDistSq = radius * radius
+	}
+
+	for len(queue) > 0 {
+		pos := queue[0]
+		queue = queue[1:]
+		source := sources[pos.Y][pos.X]
+
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				nx, ny := pos.X+dx, pos.Y+dy
+				if (dx == 0 && dy == 0) || nx < 0 || ny < 0 || nx >= width || ny >= height {
+					continue
+				}
+
+				if !isUnmatched(colorDataMatrix, overlayMask, nx, ny) {
+					continue
+				}
+
+				distSq := (nx-source.X)*(nx-source.X) + (ny-source.Y)*(ny-source.Y)
+				if distSq > maxDistSq {
+					continue
+				}
+
+				if hasSource[ny][nx] {
+					current := sources[ny][nx]
+					if (nx-current.X)*(nx-current.X)+(ny-current.Y)*(ny-current.Y) <= distSq {
+						continue
+					}
+				}
+
+				hasSource[ny][nx] = true
+				sources[ny][nx] = source
+				queue = append(queue, Position{X: nx, Y: ny})
+			}
+		}
+	}
+
+	for y := range height {
+		for x := range width {
+			if hasSource[y][x] && isUnmatched(colorDataMatrix, overlayMask, x, y) {
+				source := sources[y][x]
+				colorDataMatrix[y][x] = colorDataMatrix[source.Y][source.X]
+			}
+		}
+	}
+}
+
+func fillMajorityMatched(colorDataMatrix [][]ColorValue, overlayMask [][]bool, radius int) {
+	height := len(colorDataMatrix)
+	if height == 0 {
+		return
+	}
+	width := len(colorDataMatrix[0])
+
+	// decide every fill from the original matches before writing any, so fills don't feed into each other.
+	// neighbours are counted by their whole color value so no data is a choice of its own rather than a 0
+	type update struct {
+		position Position
+		value    ColorValue
+	}
+	updates := []update{}
+	for y := range height {
+		for x := range width {
+			if !isUnmatched(colorDataMatrix, overlayMask, x, y) {
+				continue
+			}
+
+			counts := make(map[ColorValue]int)
+			bestValue, bestCount := ColorValue{}, 0
+			for ny := max(0, y-radius); ny <= min(height-1, y+radius); ny++ {
+				for nx := max(0, x-radius); nx <= min(width-1, x+radius); nx++ {
+					neighbour := colorDataMatrix[ny][nx]
+					if !neighbour.IsWithinOverlay {
+						continue
+					}
+
+					counts[neighbour]++
+					if counts[neighbour] > bestCount {
+						bestValue, bestCount = neighbour, counts[neighbour]
+					}
+				}
+			}
+
+			if bestCount > 0 {
+				updates = append(updates, update{position: Position{X: x, Y: y}, value: bestValue})
+			}
+		}
+	}
+
+	for _, u := range updates {
+		colorDataMatrix[u.position.Y][u.position.X] = u.value
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// parseCleanupGrid reads digits as matched values, "x" as pixels matching a legend item
+// without a value, "." as unmatched pixels cleanup may fill and " " as pixels outside the mask
+func parseCleanupGrid(rows []string) ([][]ColorValue, [][]bool) {
+	colorDataMatrix := make([][]ColorValue, len(rows))
+	mask := make([][]bool, len(rows))
+	for y, row := range rows {
+		colorDataMatrix[y] = make([]ColorValue, len(row))
+		mask[y] = make([]bool, len(row))
+		for x, ch := range row {
+			mask[y][x] = ch != ' '
+			switch {
+			case ch >= '0' && ch <= '9':
+				colorDataMatrix[y][x] = ColorValue{Value: float64(ch - '0'), IsWithinOverlay: true, IsValueFound: true}
+			case ch == 'x':
+				colorDataMatrix[y][x] = ColorValue{IsWithinOverlay: true, IsValueFound: false}
+			}
+		}
+	}
+
+	return colorDataMatrix, mask
+}
+
+func formatCleanupGrid(colorDataMatrix [][]ColorValue, mask [][]bool) []string {
+	rows := make([]string, len(colorDataMatrix))
+	for y, row := range colorDataMatrix {
+		var b strings.Builder
+		for x, colorValue := range row {
+			switch {
+			case colorValue.IsWithinOverlay && !colorValue.IsValueFound:
+				b.WriteByte('x')
+			case colorValue.IsWithinOverlay:
+				b.WriteByte(byte('0' + int(colorValue.Value)))
+			case mask[y][x]:
+				b.WriteByte('.')
+			default:
+				b.WriteByte(' ')
+			}
+		}
+		rows[y] = b.String()
+	}
+
+	return rows
+}
+
+func TestFillUnmatchedPixels(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		radius int
+		in     []string
+		want   []string
+	}{
+		{
+			"nearest fills a border between regions",
+			"nearest", 0,
+			[]string{"11.22", "11.22", "11.22"},
+			[]string{"11122", "11122", "11122"},
+		},
+		{
+			"nearest respects the radius",
+			"nearest", 1,
+			[]string{"1....", "1....", "1...."},
+			[]string{"11...", "11...", "11..."},
+		},
+		{
+			"nearest never fills outside the mask",
+			"nearest", 0,
+			[]string{"11.  ", "11.  ", "     "},
+			[]string{"111  ", "111  ", "     "},
+		},
+		{
+			"majority takes the most common neighbour",
+			"majority", 1,
+			[]string{"112", "1.2", "112"},
+			[]string{"112", "112", "112"},
+		},
+		{
+			"nearest never fills over no data",
+			"nearest", 0,
+			[]string{"1x1", "1x1"},
+			[]string{"1x1", "1x1"},
+		},
+		{
+			"nearest no data spreads as no data",
+			"nearest", 0,
+			[]string{"xx..1"},
+			[]string{"xxx11"},
+		},
+		{
+			"majority never fills over no data",
+			"majority", 1,
+			[]string{"111", "1x1", "111"},
+			[]string{"111", "1x1", "111"},
+		},
+		{
+			"majority counts no data as its own choice rather than 0",
+			"majority", 1,
+			[]string{"xx0", "x.0", "xx1"},
+			[]string{"xx0", "xx0", "xx1"},
+		},
+		{
+			"none leaves pixels unmatched",
+			"none", 0,
+			[]string{"1.2"},
+			[]string{"1.2"},
+		},
+	}
+
+	for _, test := range tests {
+		colorDataMatrix, mask := parseCleanupGrid(test.in)
+		if err := fillUnmatchedPixels(colorDataMatrix, mask, test.mode, test.radius); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		got := formatCleanupGrid(colorDataMatrix, mask)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFillUnmatchedPixelsErrors(t *testing.T) {
+	colorDataMatrix, mask := parseCleanupGrid([]string{"1.2"})
+
+	if err := fillUnmatchedPixels(colorDataMatrix, mask, "majority", 0); err == nil {
+		t.Errorf("expected majority cleanup without a radius to be rejected")
+	}
+
+	if err := fillUnmatchedPixels(colorDataMatrix, mask, "blur", 1); err == nil {
+		t.Errorf("expected an unknown cleanup mode to be rejected")
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+)
+
+const defaultRegionPercentile float64 = 90
+
+// findCandidateAreas picks the request's top N distinct areas among the scored cells
+func findCandidateAreas(request AggregateDataRequest, cells []PositionValue, allResults []TaggedImageData, gapX, gapY float64, overlayLatLongBounds OverlayBounds) ([]CandidateArea, error) {
+	areaCellGroups, err := findCandidateAreaCells(request, cells, gapX, gapY, overlayLatLongBounds)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []CandidateArea{}
+	for _, areaCells := range areaCellGroups {
+		candidates = append(candidates, newCandidateArea(areaCells, allResults, gapX, gapY, overlayLatLongBounds))
+	}
+
+	return candidates, nil
+}
+
+// findCandidateAreaCells gives the cells making up each of the top N areas, best first
+func findCandidateAreaCells(request AggregateDataRequest, cells []PositionValue, gapX, gapY float64, overlayLatLongBounds OverlayBounds) ([][]PositionValue, error) {
+	if request.TopN < 0 {
+		return nil, fmt.Errorf("top n must not be negative")
+	}
+
+	if request.TopN == 0 || len(cells) == 0 {
+		return [][]PositionValue{}, nil
+	}
+
+	cellLatLong := func(cell PositionValue) LatLong {
+		lat, long := getLatLong(cell.Position.X, cell.Position.Y, gapX, gapY, overlayLatLongBounds)
+		return LatLong{Lat: lat, Long: long}
+	}
+
+	areaCellGroups := [][]PositionValue{}
+	switch request.CandidateMode {
+	case "", "peaks":
+		if request.MinSeparationMiles <= 0 {
+			return nil, fmt.Errorf("peak candidates need a positive min separation")
+		}
+
+		sorted := slices.Clone(cells)
+		slices.SortStableFunc(sorted, func(a, b PositionValue) int { return compareDesc(a.Value, b.Value) })
+
+		// the best remaining cell not too close to an already chosen one is the next peak
+		peaks := []LatLong{}
+		for _, cell := range sorted {
+			if len(peaks) == request.TopN {
+				break
+			}
+
+			latLong := cellLatLong(cell)
+			if !slices.ContainsFunc(peaks, func(peak LatLong) bool { return distanceMiles(peak, latLong) < request.MinSeparationMiles }) {
+				peaks = append(peaks, latLong)
+				areaCellGroups = append(areaCellGroups, []PositionValue{})
+			}
+		}
+
+		// each peak's area is its surroundings up to half the separation, so areas never overlap
+		for _, cell := range cells {
+			latLong := cellLatLong(cell)
+			for i, peak := range peaks {
+				if distanceMiles(peak, latLong) < request.MinSeparationMiles/2 {
+					areaCellGroups[i] = append(areaCellGroups[i], cell)
+					break
+				}
+			}
+		}
+	case "regions":
+		percentile := request.RegionPercentile
+		if percentile == 0 {
+			percentile = defaultRegionPercentile
+		}
+		if percentile < 0 || percentile > 100 {
+			return nil, fmt.Errorf("region percentile %f must be between 0 and 100", percentile)
+		}
+
+		areaCellGroups = regionsAbovePercentile(cells, percentile)
+		slices.SortStableFunc(areaCellGroups, func(a, b []PositionValue) int { return compareDesc(meanCellValue(a), meanCellValue(b)) })
+		areaCellGroups = areaCellGroups[:min(request.TopN, len(areaCellGroups))]
+	default:
+		return nil, fmt.Errorf("unknown candidate mode %s, expected peaks or regions", request.CandidateMode)
+	}
+
+	return areaCellGroups, nil
+}
+
+// regionsAbovePercentile groups the cells scoring at or above the percentile into 8-connected regions
+func regionsAbovePercentile(cells []PositionValue, percentile float64) [][]PositionValue {
+	values := make([]float64, len(cells))
+	for i, cell := range cells {
+		values[i] = cell.Value
+	}
+	slices.Sort(values)
+	threshold := values[min(len(values)-1, int(math.Floor(percentile/100*float64(len(values)))))]
+
+	cellIByPosition := make(map[Position]int)
+	for i, cell := range cells {
+		if cell.Value >= threshold {
+			cellIByPosition[cell.Position] = i
+		}
+	}
+
+	visited := make(map[Position]bool)
+	regions := [][]PositionValue{}
+	for _, cell := range cells {
+		if _, isAbove := cellIByPosition[cell.Position]; !isAbove || visited[cell.Position] {
+			continue
+		}
+
+		region := []PositionValue{}
+		visited[cell.Position] = true
+		queue := []Position{cell.Position}
+		for len(queue) > 0 {
+			pos := queue[0]
+			queue = queue[1:]
+			region = append(region, cells[cellIByPosition[pos]])
+
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					neighbour := Position{X: pos.X + dx, Y: pos.Y + dy}
+					if _, isAbove := cellIByPosition[neighbour]; isAbove && !visited[neighbour] {
+						visited[neighbour] = true
+						queue = append(queue, neighbour)
+					}
+				}
+			}
+		}
+
+		regions = append(regions, region)
+	}
+
+	return regions
+}
+
+func newCandidateArea(cells []PositionValue, allResults []TaggedImageData, gapX, gapY float64, overlayLatLongBounds OverlayBounds) CandidateArea {
+	area := CandidateArea{NumCells: len(cells), PeakScore: math.Inf(-1), TagScores: map[string]float64{}}
+
+	tagScoreSums := make([]float64, len(allResults))
+	tagCounts := make([]int, len(allResults))
+	for _, cell := range cells {
+		lat, long := getLatLong(cell.Position.X, cell.Position.Y, gapX, gapY, overlayLatLongBounds)
+
+		area.Centroid.Lat += lat
+		area.Centroid.Long += long
+		area.MeanScore += cell.Value
+		area.AreaSqMiles += gapY * MilesPerLatLongDegree * gapX * MilesPerLatLongDegree * math.Cos(degToRad(lat))
+		if cell.Value > area.PeakScore {
+			area.PeakScore = cell.Value
+			area.Peak = LatLong{Lat: lat, Long: long}
+		}
+
+		for i, result := range allResults {
+			if result.NoData != nil && result.NoData[cell.Position.Y][cell.Position.X] {
+				continue
+			}
+			tagScoreSums[i] += result.scores[cell.Position.Y][cell.Position.X]
+			tagCounts[i]++
+		}
+	}
+
+	area.Centroid.Lat /= float64(len(cells))
+	area.Centroid.Long /= float64(len(cells))
+	area.MeanScore /= float64(len(cells))
+
+	for i, result := range allResults {
+		if tagCounts[i] > 0 {
+			area.TagScores[result.Tag] = tagScoreSums[i] / float64(tagCounts[i])
+		}
+	}
+
+	return area
+}
+
+func meanCellValue(cells []PositionValue) float64 {
+	sum := 0.0
+	for _, cell := range cells {
+		sum += cell.Value
+	}
+
+	return sum / float64(len(cells))
+}
+
+func compareDesc(a, b float64) int {
+	if a > b {
+		return -1
+	} else if a < b {
+		return 1
+	}
+
+	return 0
+}
+
+// distanceMiles is the equirectangular approximation of the distance between two
+// lat/longs, which is accurate enough over the extent of a single overlay
+func distanceMiles(a, b LatLong) float64 {
+	dLat := (a.Lat - b.Lat) * MilesPerLatLongDegree
+	dLong := (a.Long - b.Long) * MilesPerLatLongDegree * math.Cos(degToRad((a.Lat+b.Lat)/2))
+
+	return math.Hypot(dLat, dLong)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// parseCandidateGrid reads each digit as a cell scoring a tenth of it, and "." as a cell that isn't scored
+func parseCandidateGrid(rows []string) []PositionValue {
+	cells := []PositionValue{}
+	for y, row := range rows {
+		for x, ch := range row {
+			if ch >= '0' && ch <= '9' {
+				cells = append(cells, PositionValue{Position: Position{X: x, Y: y}, Value: float64(ch-'0') / 10})
+			}
+		}
+	}
+
+	return cells
+}
+
+// formatCandidateGrid marks the cells of each area with a letter, "a" for the best
+func formatCandidateGrid(rows []string, areaCellGroups [][]PositionValue) []string {
+	grid := make([][]byte, len(rows))
+	for y, row := range rows {
+		grid[y] = []byte(strings.Repeat(".", len(row)))
+	}
+
+	for i, areaCells := range areaCellGroups {
+		for _, cell := range areaCells {
+			grid[cell.Position.Y][cell.Position.X] = byte('a' + i)
+		}
+	}
+
+	formatted := make([]string, len(grid))
+	for y, row := range grid {
+		formatted[y] = string(row)
+	}
+
+	return formatted
+}
+
+func TestFindCandidateAreaCells(t *testing.T) {
+	// one degree cells near the equator, so neighbouring cells are about 69 miles apart
+	tests := []struct {
+		name    string
+		request AggregateDataRequest
+		in      []string
+		want    []string
+	}{
+		{
+			"peaks split a ridge at half the separation",
+			AggregateDataRequest{TopN: 2, MinSeparationMiles: 150},
+			[]string{"9876"},
+			[]string{"aabb"},
+		},
+		{
+			"peaks are the default mode",
+			AggregateDataRequest{TopN: 2, CandidateMode: "peaks", MinSeparationMiles: 150},
+			[]string{"9876"},
+			[]string{"aabb"},
+		},
+		{
+			"peaks stop at top n",
+			AggregateDataRequest{TopN: 1, MinSeparationMiles: 150},
+			[]string{"98..7", "8...6"},
+			[]string{"aa...", "a...."},
+		},
+		{
+			"peaks skip cells too close to a better one",
+			AggregateDataRequest{TopN: 3, MinSeparationMiles: 150},
+			[]string{"98..7", "8...6"},
+			[]string{"aa..b", "a...b"},
+		},
+		{
+			"regions keep a ridge whole",
+			AggregateDataRequest{TopN: 2, CandidateMode: "regions", RegionPercentile: 1},
+			[]string{"9876"},
+			[]string{"aaaa"},
+		},
+		{
+			"regions only take cells at or above the percentile",
+			AggregateDataRequest{TopN: 2, CandidateMode: "regions", RegionPercentile: 50},
+			[]string{"9876"},
+			[]string{"aa.."},
+		},
+		{
+			"regions connect diagonally",
+			AggregateDataRequest{TopN: 2, CandidateMode: "regions", RegionPercentile: 1},
+			[]string{"9.", ".8"},
+			[]string{"a.", ".a"},
+		},
+		{
+			"regions are ordered by their mean score",
+			AggregateDataRequest{TopN: 2, CandidateMode: "regions", RegionPercentile: 1},
+			[]string{"99.5.7"},
+			[]string{"aa...b"},
+		},
+		{
+			"no areas without top n",
+			AggregateDataRequest{CandidateMode: "regions"},
+			[]string{"9876"},
+			[]string{"...."},
+		},
+	}
+
+	for _, test := range tests {
+		bounds := OverlayBounds{
+			TopLeft:     LatLong{Lat: float64(len(test.in)), Long: 0},
+			BottomRight: LatLong{Lat: 0, Long: float64(len(test.in[0]))},
+		}
+
+		areaCellGroups, err := findCandidateAreaCells(test.request, parseCandidateGrid(test.in), 1, 1, bounds)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		got := formatCandidateGrid(test.in, areaCellGroups)
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFindCandidateAreaCellsErrors(t *testing.T) {
+	cells := parseCandidateGrid([]string{"98"})
+	bounds := OverlayBounds{TopLeft: LatLong{Lat: 1}, BottomRight: LatLong{Long: 2}}
+
+	tests := []struct {
+		name    string
+		request AggregateDataRequest
+		wantErr string
+	}{
+		{"negative top n", AggregateDataRequest{TopN: -1, MinSeparationMiles: 10}, "must not be negative"},
+		{"peaks without a separation", AggregateDataRequest{TopN: 1}, "positive min separation"},
+		{"percentile above 100", AggregateDataRequest{TopN: 1, CandidateMode: "regions", RegionPercentile: 101}, "between 0 and 100"},
+		{"unknown mode", AggregateDataRequest{TopN: 1, CandidateMode: "clusters"}, "unknown candidate mode"},
+	}
+
+	for _, test := range tests {
+		if _, err := findCandidateAreaCells(test.request, cells, 1, 1, bounds); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: error = %v, want one containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
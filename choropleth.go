@@ -1,17 +1,13 @@
 package main
 
 import (
-	"bytes"
-	"encoding/csv"
 	"fmt"
 	"image"
 	"image/color"
-	"image/png"
 	"io"
 	"math"
 	"regexp"
 	"slices"
-	"strconv"
 	"strings"
 	"sync"
 
@@ -42,30 +38,54 @@ type LocationValue struct {
 	Value    float64
 }
 
-func submitChoroplethMap(submittedFile io.Reader, data SubmitChoroplethMapData) (*image.RGBA, error) {
-	overlayMapImg, err := getOverlayFile()
+func submitChoroplethMap(submittedFile io.Reader, data SubmitChoroplethMapData) (*image.RGBA, *RegistrationReport, ChoroplethDiagnostics, error) {
+	var diagnostics ChoroplethDiagnostics
+
+	overlayMapImg, overlayLatLongBounds, err := getOverlayData()
 	if err != nil {
-		return nil, err
+		return nil, nil, diagnostics, err
 	}
 
 	overlayBounds := overlayMapImg.Bounds()
 
-	var buf bytes.Buffer
-	if _, err := buf.ReadFrom(submittedFile); err != nil {
-		return nil, fmt.Errorf("oops on read sf")
+	rgbaSubmittedImage, err := decodeSubmittedImage(submittedFile, data.ImagePlacement)
+	if err != nil {
+		return nil, nil, diagnostics, err
 	}
 
-	submittedImg, err := png.Decode(&buf)
+	submittedImgBounds := rgbaSubmittedImage.Bounds()
+
+	mapper, registrationReport, err := newSubmittedImageMapper(data.ImagePlacement, overlayBounds, overlayLatLongBounds)
 	if err != nil {
-		return nil, fmt.Errorf("oops on decode")
+		return nil, nil, diagnostics, err
 	}
 
-	rgbaSubmittedImage := decodeToRGBA(submittedImg)
-	submittedImgBounds := rgbaSubmittedImage.Bounds()
+	var classifier LegendClassifier
+	switch data.LegendMode {
+	case "", "discrete":
+		palette, err := newLegendPalette(data.Legend, data.DeltaETolerance, data.ColorTolerance)
+		if err != nil {
+			return nil, nil, diagnostics, err
+		}
+
+		classifier = palette
+	case "gradient":
+		ramp, err := newGradientRamp(data.GradientStops, data.MaxGradientDistance)
+		if err != nil {
+			return nil, nil, diagnostics, err
+		}
 
-	submittedImagePxPerOverlayPx := float64(data.OverlayLocBottomRightY-data.OverlayLocTopLeftY) / float64(overlayBounds.Max.Y)
+		classifier = ramp
+	default:
+		return nil, nil, diagnostics, fmt.Errorf("unknown legend mode %s, expected discrete or gradient", data.LegendMode)
+	}
 
 	colorDataMatrix := initDataMatrix[ColorValue](overlayBounds)
+	overlayMask := initDataMatrix[bool](overlayBounds)
+	// overlay pixels the submitted image covers, the only ones cleanup may fill
+	footprintMask := initDataMatrix[bool](overlayBounds)
+	// index of the legend item each pixel matched before cleanup, or -1
+	legendMatches := initDataMatrix[int](overlayBounds)
 
 	var wg sync.WaitGroup
 	for oy := range overlayBounds.Max.Y {
@@ -74,40 +94,28 @@ func submitChoroplethMap(submittedFile io.Reader, data SubmitChoroplethMapData)
 			defer wg.Done()
 			for ox := range overlayBounds.Max.X {
 				isRelevant := isWithinOverlay(overlayMapImg, ox, oy)
+				overlayMask[oy][ox] = isRelevant
 
-				sx := data.OverlayLocTopLeftX + int(float64(ox)*submittedImagePxPerOverlayPx)
-				sy := data.OverlayLocTopLeftY + int(float64(oy)*submittedImagePxPerOverlayPx)
+				sx, sy := mapper.SourcePixel(ox, oy)
 
 				newColor := ColorValue{IsWithinOverlay: false}
+				legendMatches[oy][ox] = -1
 
 				if sx >= 0 && sx < submittedImgBounds.Max.X && sy >= 0 && sy < submittedImgBounds.Max.Y {
+					footprintMask[oy][ox] = isRelevant
+
 					sr, sg, sb, sa := getRgba(rgbaSubmittedImage, sx, sy)
 					scolor := [4]uint8{sr, sg, sb, sa}
 
 					if isRelevant {
-						bestLegendItemI := -1
-						for i, legendItem := range data.Legend {
-							dr, dg, db, da := colorDiff(scolor, legendItem.Color)
-
-							if dr < data.ColorTolerance && dg < data.ColorTolerance && db < data.ColorTolerance && da < data.ColorTolerance {
-								if bestLegendItemI != -1 {
-									bdr, bdg, bdb, bda := colorDiff(data.Legend[bestLegendItemI].Color, legendItem.Color)
-									if dr < bdr && dg < bdg && db < bdb && da < bda {
-										bestLegendItemI = i
-									}
-								} else {
-									bestLegendItemI = i
-								}
-							}
-						}
-
-						if bestLegendItemI != -1 {
-							value := data.Legend[bestLegendItemI].Value
-							if value != nil {
-								newColor = ColorValue{Value: *value, IsWithinOverlay: true}
-								// newColor = valueColor(*value)
-							}
+						value, legendItemI, found := classifier.Value(scolor)
+						if found {
+							newColor = ColorValue{Value: value, IsWithinOverlay: true, IsValueFound: true}
+						} else if legendItemI != -1 {
+							// a legend item without a value marks no data, which cleanup mustn't fill over
+							newColor = ColorValue{IsWithinOverlay: true, IsValueFound: false}
 						}
+						legendMatches[oy][ox] = legendItemI
 					}
 				}
 
@@ -120,95 +128,30 @@ func submitChoroplethMap(submittedFile io.Reader, data SubmitChoroplethMapData)
 
 	wg.Wait()
 
-	for range data.BorderTolerance {
-		wg = sync.WaitGroup{}
-		updates := make(chan PositionValue, overlayBounds.Max.X*overlayBounds.Max.Y)
-
-		islandSizeMatrix := buildIslandMatrix(colorDataMatrix, overlayBounds)
-
-		for y := range overlayBounds.Max.Y {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				for x := range overlayBounds.Max.X {
-					isRelevant := isWithinOverlay(overlayMapImg, x, y)
-					// hasValue := hasValue(colorDataMatrix, x, y)
-
-					if isRelevant {
-						colorVal := inheritLargestSiblingColor(colorDataMatrix, islandSizeMatrix, x, y)
-						if colorVal.IsWithinOverlay {
-							updates <- PositionValue{Position: Position{X: x, Y: y}, Value: colorVal.Value}
-						}
-					}
-				}
-			}()
-		}
-
-		wg.Wait()
-
-		close(updates)
-
-		for update := range updates {
-			colorDataMatrix[update.Position.Y][update.Position.X] = ColorValue{Value: update.Value, IsWithinOverlay: true}
-		}
+	// borderTolerance predates the cleanup modes and meant filling borders up to that many pixels thick
+	cleanupMode, cleanupRadius := data.CleanupMode, data.CleanupRadius
+	if cleanupMode == "" && data.BorderTolerance > 0 {
+		cleanupMode, cleanupRadius = "nearest", data.BorderTolerance
 	}
 
-	newImg := colorDataMatrixToImage(colorDataMatrix, overlayBounds)
-
-	return newImg, nil
-}
+	if err := fillUnmatchedPixels(colorDataMatrix, footprintMask, cleanupMode, cleanupRadius); err != nil {
+		return nil, nil, diagnostics, err
+	}
 
-func buildIslandMatrix(colorDataMatrix [][]ColorValue, overlayBounds image.Rectangle) [][]int {
-	islandSizeMatrix := initDataMatrix[int](overlayBounds)
-	visited := initDataMatrix[bool](overlayBounds)
+	diagnostics = buildChoroplethDiagnostics(colorDataMatrix, overlayMask, legendMatches, classifier.Items(), data.Diagnostics)
 
-	for y := range overlayBounds.Max.Y {
-		for x := range overlayBounds.Max.X {
-			if colorDataMatrix[y][x].IsWithinOverlay && !visited[y][x] {
-				allIslandPositions := []Position{}
-				queue := []Position{{X: x, Y: y}}
-				for len(queue) != 0 {
-					pos := queue[0]
-					queue = queue[1:]
-
-					newPositions := expand(colorDataMatrix, visited, pos.X, pos.Y)
-
-					queue = append(queue, newPositions...)
-					allIslandPositions = append(allIslandPositions, newPositions...)
-					for _, newPos := range newPositions {
-						visited[newPos.Y][newPos.X] = true
-					}
-				}
-
-				islandSize := len(allIslandPositions)
-				for _, pos := range allIslandPositions {
-					islandSizeMatrix[pos.Y][pos.X] = islandSize
-				}
+	// whatever cleanup couldn't fill has no data, rather than being left transparent like water
+	for y, row := range colorDataMatrix {
+		for x := range row {
+			if isUnmatched(colorDataMatrix, overlayMask, x, y) {
+				colorDataMatrix[y][x] = ColorValue{IsWithinOverlay: true, IsValueFound: false}
 			}
 		}
 	}
 
-	return islandSizeMatrix
-}
-
-func expand(img [][]ColorValue, visited [][]bool, x, y int) []Position {
-	islandColor := img[y][x].Value
-
-	expanded := []Position{}
-	if y > 0 && img[y-1][x].IsWithinOverlay && img[y-1][x].Value == islandColor && !visited[y-1][x] {
-		expanded = append(expanded, Position{X: x, Y: y - 1})
-	}
-	if x > 0 && img[y][x-1].IsWithinOverlay && img[y][x-1].Value == islandColor && !visited[y][x-1] {
-		expanded = append(expanded, Position{X: x - 1, Y: y})
-	}
-	if y < len(img)-1 && img[y+1][x].IsWithinOverlay && img[y+1][x].Value == islandColor && !visited[y+1][x] {
-		expanded = append(expanded, Position{X: x, Y: y + 1})
-	}
-	if x < len(img[0])-1 && img[y][x+1].IsWithinOverlay && img[y][x+1].Value == islandColor && !visited[y][x+1] {
-		expanded = append(expanded, Position{X: x + 1, Y: y})
-	}
+	newImg := colorDataMatrixToImage(colorDataMatrix, overlayBounds)
 
-	return expanded
+	return newImg, registrationReport, diagnostics, nil
 }
 
 func initDataMatrix[T any](bounds image.Rectangle) [][]T {
@@ -220,52 +163,52 @@ func initDataMatrix[T any](bounds image.Rectangle) [][]T {
 	return rows
 }
 
-func hasValue(img [][]ColorValue, x, y int) bool {
-	val := img[y][x]
-	return val.IsWithinOverlay
-}
+func submitChoroplethMapFromCsv(geoJsonFile, locationCsvFile io.Reader, data SubmitChoroplethMapFromCsvData) (*image.RGBA, CsvReport, error) {
+	report := CsvReport{RowErrors: []CsvRowError{}}
 
-func submitChoroplethMapFromCsv(geoJsonFile, locationCsvFile io.Reader, data SubmitChoroplethMapFromCsvData) (*image.RGBA, error) {
 	geoJsonBytes, err := io.ReadAll(geoJsonFile)
 	if err != nil {
-		return nil, err
+		return nil, report, err
 	}
 
 	fc, err := geojson.UnmarshalFeatureCollection(geoJsonBytes)
 	if err != nil {
-		return nil, err
+		return nil, report, err
 	}
 
 	for _, feature := range fc.Features {
 		featureNameObj, found := feature.Properties[data.GeoJsonNameProperty]
 		if !found {
-			return nil, fmt.Errorf("could not find name for feature")
+			return nil, report, fmt.Errorf("could not find name for feature")
 		}
 
 		_, isString := featureNameObj.(string)
 		if !isString {
-			return nil, fmt.Errorf("feature name not string")
+			return nil, report, fmt.Errorf("feature name not string")
 		}
 
 		geoJsonType := feature.Geometry.GeoJSONType()
 		if geoJsonType != "MultiPolygon" && geoJsonType != "Polygon" {
-			return nil, fmt.Errorf("geometry not Polygon nor MultiPolygon")
+			return nil, report, fmt.Errorf("geometry not Polygon nor MultiPolygon")
 		}
 	}
 
-	locationValues, err := readLocationValuesFromCsv(locationCsvFile, data.CsvNameColumn, data.CsvValueColumn)
+	locationValues, rowErrors, err := readLocationValuesFromCsv(locationCsvFile, data.CsvNameColumn, data.CsvValueColumn, data.SkipBadRows)
+	if rowErrors != nil {
+		report.RowErrors = rowErrors
+	}
 	if err != nil {
-		return nil, err
+		return nil, report, err
 	}
 
 	locationValByFeatureName, err := getLocationValByFeatureName(fc, locationValues, data)
 	if err != nil {
-		return nil, err
+		return nil, report, err
 	}
 
 	overlayMapImg, overlayLatLongBounds, err := getOverlayData()
 	if err != nil {
-		return nil, err
+		return nil, report, err
 	}
 
 	overlayBounds := overlayMapImg.Bounds()
@@ -331,7 +274,7 @@ func submitChoroplethMapFromCsv(geoJsonFile, locationCsvFile io.Reader, data Sub
 
 	newImg := colorDataMatrixToImage(colorDataMatrix, overlayBounds)
 
-	return newImg, nil
+	return newImg, report, nil
 }
 
 func getLocationValByFeatureName(fc *geojson.FeatureCollection, locationValues []LocationValue, data SubmitChoroplethMapFromCsvData) (map[string]LocationValue, error) {
@@ -400,39 +343,6 @@ func normalizeName(nonAlphaNumRegex, whitespaceRegex *regexp.Regexp, name string
 	return name
 }
 
-func inheritLargestSiblingColor(img [][]ColorValue, islandSizeMatrix [][]int, x, y int) ColorValue {
-	// positions := []Position{{X: x, Y: y}}
-	positions := []Position{}
-
-	if hasValue(img, x, y) {
-		positions = append(positions, Position{X: x, Y: y})
-	}
-	if x > 0 && hasValue(img, x-1, y) {
-		positions = append(positions, Position{X: x - 1, Y: y})
-	}
-	if y > 0 && hasValue(img, x, y-1) {
-		positions = append(positions, Position{X: x, Y: y - 1})
-	}
-	if x < len(img[0])-1 && hasValue(img, x+1, y) {
-		positions = append(positions, Position{X: x + 1, Y: y})
-	}
-	if y < len(img)-1 && hasValue(img, x, y+1) {
-		positions = append(positions, Position{X: x, Y: y + 1})
-	}
-
-	var bestColor ColorValue
-	largestIslandSize := 0
-	for _, pos := range positions {
-		islandSize := islandSizeMatrix[pos.Y][pos.X]
-		if islandSize > largestIslandSize {
-			bestColor = img[pos.Y][pos.X]
-			largestIslandSize = islandSize
-		}
-	}
-
-	return bestColor
-}
-
 func colorDataMatrixToImage(colorDataMatrix [][]ColorValue, overlayBounds image.Rectangle) *image.RGBA {
 	newImg := image.NewRGBA(image.Rect(0, 0, overlayBounds.Max.X, overlayBounds.Max.Y))
 	for y, row := range colorDataMatrix {
@@ -442,7 +352,7 @@ func colorDataMatrixToImage(colorDataMatrix [][]ColorValue, overlayBounds image.
 				if val.IsValueFound {
 					pixelColor = color.RGBA{R: 0, G: uint8(val.Value * 255), B: 0, A: 255}
 				} else {
-					pixelColor = color.RGBA{R: 220, G: 0, B: 0, A: 255}
+					pixelColor = noDataColor
 				}
 
 				newImg.Set(x, y, pixelColor)
@@ -453,45 +363,40 @@ func colorDataMatrixToImage(colorDataMatrix [][]ColorValue, overlayBounds image.
 	return newImg
 }
 
-func readLocationValuesFromCsv(submittedFile io.Reader, nameCol, valCol string) ([]LocationValue, error) {
-	var buf bytes.Buffer
-	bytesRead, err := buf.ReadFrom(submittedFile)
+func readLocationValuesFromCsv(submittedFile io.Reader, nameCol, valCol string, skipBadRows bool) ([]LocationValue, []CsvRowError, error) {
+	csvReader, err := newCsvReader(submittedFile, skipBadRows)
 	if err != nil {
-		return nil, fmt.Errorf("oops on read sf")
+		return nil, nil, err
 	}
 
-	if bytesRead > 10_000_000 {
-		return nil, fmt.Errorf("max file size of 10MB exceeded")
-	}
-
-	csvReader := csv.NewReader(&buf)
-	rows, err := csvReader.ReadAll()
-	if err != nil {
-		return nil, fmt.Errorf("failed to read CSV from file: %w", err)
-	}
-
-	if len(rows) < 1 {
-		return nil, fmt.Errorf("read csv unexpectedly empty")
-	}
-
-	header := rows[0]
-	nameI := slices.Index(header, nameCol)
+	nameI := csvReader.ColumnIndex(nameCol)
 	if nameI == -1 {
-		return nil, fmt.Errorf("name col unexpectedly not found. Found %s", strings.Join(header, ", "))
+		return nil, nil, fmt.Errorf("name col unexpectedly not found. Found %s", strings.Join(csvReader.Header(), ", "))
 	}
 
-	valI := slices.Index(header, valCol)
+	valI := csvReader.ColumnIndex(valCol)
 	if valI == -1 {
-		return nil, fmt.Errorf("value col unexpectedly not found")
+		return nil, nil, fmt.Errorf("value col unexpectedly not found")
 	}
 
 	result := []LocationValue{}
-	for _, row := range rows[1:] {
-		name := row[nameI]
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, csvReader.RowErrors(), err
+		}
 
-		val, err := strconv.ParseFloat(row[valI], 64)
+		name := strings.TrimSpace(row[nameI])
+
+		val, ok, err := csvReader.ParseFloat(row, valI)
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse long %s to float: %w", row[valI], err)
+			return nil, csvReader.RowErrors(), err
+		}
+		if !ok {
+			continue
 		}
 
 		result = append(result, LocationValue{
@@ -500,5 +405,5 @@ func readLocationValuesFromCsv(submittedFile io.Reader, nameCol, valCol string)
 		})
 	}
 
-	return result, nil
+	return result, csvReader.RowErrors(), nil
 }
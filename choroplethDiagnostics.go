@@ -0,0 +1,65 @@
+package main
+
+import (
+	"image"
+	"image/color"
+)
+
+var (
+	diagnosticFilledColor    = color.RGBA{R: 255, G: 165, B: 0, A: 255}
+	diagnosticUnmatchedColor = color.RGBA{R: 255, G: 0, B: 255, A: 255}
+)
+
+func diagnosticLayerTag(tag string) string {
+	return tag + "-diagnostics"
+}
+
+// buildChoroplethDiagnostics counts the overlay pixels matching each legend
+// item before cleanup, and those cleanup filled or left unmatched. Pixels
+// claimed by a legend item without a value count as matched but stay valueless.
+func buildChoroplethDiagnostics(colorDataMatrix [][]ColorValue, overlayMask [][]bool, legendMatches [][]int, legendItems []LegendItem, withLayer bool) ChoroplethDiagnostics {
+	diagnostics := ChoroplethDiagnostics{LegendItems: []LegendItemCoverage{}}
+	for _, legendItem := range legendItems {
+		diagnostics.LegendItems = append(diagnostics.LegendItems, LegendItemCoverage{Color: legendItem.Color, Value: legendItem.Value})
+	}
+
+	if withLayer && len(colorDataMatrix) > 0 {
+		diagnostics.layer = image.NewRGBA(image.Rect(0, 0, len(colorDataMatrix[0]), len(colorDataMatrix)))
+	}
+
+	for y, row := range colorDataMatrix {
+		for x, val := range row {
+			if !overlayMask[y][x] {
+				continue
+			}
+
+			diagnostics.OverlayPixels++
+
+			legendItemI := legendMatches[y][x]
+			switch {
+			case legendItemI != -1:
+				diagnostics.MatchedPixels++
+				diagnostics.LegendItems[legendItemI].PixelCount++
+			case val.IsWithinOverlay:
+				diagnostics.FilledPixels++
+				if diagnostics.layer != nil {
+					diagnostics.layer.Set(x, y, diagnosticFilledColor)
+				}
+			default:
+				diagnostics.UnmatchedPixels++
+				if diagnostics.layer != nil {
+					diagnostics.layer.Set(x, y, diagnosticUnmatchedColor)
+				}
+			}
+		}
+	}
+
+	if diagnostics.OverlayPixels > 0 {
+		diagnostics.Coverage = float64(diagnostics.MatchedPixels) / float64(diagnostics.OverlayPixels)
+		for i := range diagnostics.LegendItems {
+			diagnostics.LegendItems[i].Coverage = float64(diagnostics.LegendItems[i].PixelCount) / float64(diagnostics.OverlayPixels)
+		}
+	}
+
+	return diagnostics
+}
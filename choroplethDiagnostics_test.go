@@ -0,0 +1,60 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+func TestBuildChoroplethDiagnostics(t *testing.T) {
+	legendItems := []LegendItem{{Color: [4]uint8{0, 0, 255, 255}, Value: ptr(0.5)}, {Color: [4]uint8{128, 128, 128, 255}}}
+
+	// a matched pixel, one claimed by the no data item, one cleanup filled, one left unmatched,
+	// one outside the overlay and another matched pixel
+	colorDataMatrix := [][]ColorValue{{
+		{Value: 0.5, IsWithinOverlay: true, IsValueFound: true},
+		{IsWithinOverlay: true},
+		{Value: 0.5, IsWithinOverlay: true, IsValueFound: true},
+		{},
+		{},
+		{Value: 0.5, IsWithinOverlay: true, IsValueFound: true},
+	}}
+	overlayMask := [][]bool{{true, true, true, true, false, true}}
+	legendMatches := [][]int{{0, 1, -1, -1, -1, 0}}
+
+	for _, withLayer := range []bool{false, true} {
+		diagnostics := buildChoroplethDiagnostics(colorDataMatrix, overlayMask, legendMatches, legendItems, withLayer)
+
+		if diagnostics.OverlayPixels != 5 || diagnostics.MatchedPixels != 3 || diagnostics.FilledPixels != 1 || diagnostics.UnmatchedPixels != 1 {
+			t.Errorf("layer %v: counts %+v, want 5 overlay, 3 matched, 1 filled and 1 unmatched", withLayer, diagnostics)
+		}
+		if math.Abs(diagnostics.Coverage-0.6) > 1e-9 {
+			t.Errorf("layer %v: coverage %v, want 0.6", withLayer, diagnostics.Coverage)
+		}
+
+		wantItems := []struct {
+			pixelCount int
+			coverage   float64
+		}{{2, 0.4}, {1, 0.2}}
+		for i, want := range wantItems {
+			item := diagnostics.LegendItems[i]
+			if item.PixelCount != want.pixelCount || math.Abs(item.Coverage-want.coverage) > 1e-9 {
+				t.Errorf("layer %v: legend item %d has %d pixels, %v coverage, want %d, %v", withLayer, i, item.PixelCount, item.Coverage, want.pixelCount, want.coverage)
+			}
+		}
+
+		if !withLayer {
+			if diagnostics.layer != nil {
+				t.Errorf("layer built without being requested")
+			}
+			continue
+		}
+
+		wantLayer := []color.RGBA{{}, {}, diagnosticFilledColor, diagnosticUnmatchedColor, {}, {}}
+		for x, want := range wantLayer {
+			if got := diagnostics.layer.RGBAAt(x, 0); got != want {
+				t.Errorf("layer pixel %d = %v, want %v", x, got, want)
+			}
+		}
+	}
+}
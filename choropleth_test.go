@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestSubmitChoroplethMapKeepsNoDataLegendItems(t *testing.T) {
+	chdirTemp(t)
+
+	writeOverlayFixture(t, 5, 1, func(x, y int) bool { return true })
+
+	blue := color.NRGBA{B: 255, A: 255}
+	gray := color.NRGBA{R: 128, G: 128, B: 128, A: 255}
+
+	// a no data region in the middle of valued ones, which cleanup used to paint over
+	submitted := image.NewNRGBA(image.Rect(0, 0, 5, 1))
+	for x, c := range []color.NRGBA{blue, blue, gray, blue, blue} {
+		submitted.SetNRGBA(x, 0, c)
+	}
+
+	var submittedPng bytes.Buffer
+	if err := png.Encode(&submittedPng, submitted); err != nil {
+		t.Fatal(err)
+	}
+
+	// 0.5 is stored truncated to 127
+	valueColor := color.RGBA{G: 127, A: 255}
+	want := []color.RGBA{valueColor, valueColor, noDataColor, valueColor, valueColor}
+
+	for _, cleanupMode := range []string{"none", "nearest", "majority"} {
+		data := SubmitChoroplethMapData{
+			ImagePlacement: ImagePlacement{OverlayLocBottomRightX: 5, OverlayLocBottomRightY: 1},
+			Tag:            "noDataLegend",
+			CleanupMode:    cleanupMode,
+			CleanupRadius:  1,
+			Legend: []LegendItem{
+				{Color: [4]uint8{blue.R, blue.G, blue.B, blue.A}, Value: ptr(0.5)},
+				{Color: [4]uint8{gray.R, gray.G, gray.B, gray.A}},
+			},
+		}
+
+		img, _, diagnostics, err := submitChoroplethMap(bytes.NewReader(submittedPng.Bytes()), data)
+		if err != nil {
+			t.Errorf("%s: %v", cleanupMode, err)
+			continue
+		}
+
+		for x, wantColor := range want {
+			if got := img.RGBAAt(x, 0); got != wantColor {
+				t.Errorf("%s: pixel %d = %v, want %v", cleanupMode, x, got, wantColor)
+			}
+		}
+
+		if diagnostics.MatchedPixels != 5 || diagnostics.FilledPixels != 0 || diagnostics.LegendItems[1].PixelCount != 1 {
+			t.Errorf("%s: diagnostics %+v, want every pixel matched and the no data item claiming one", cleanupMode, diagnostics)
+		}
+	}
+}
@@ -23,6 +23,7 @@ export interface SubmitChoroplethMapRequest {
   overlayLocBottomRightX: number;
   overlayLocBottomRightY: number;
   colorTolerance: number;
+  deltaETolerance?: number;
   borderTolerance: number;
   legend: LegendItem[];
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// LegendClassifier assigns a value to a pixel color of a submitted choropleth image,
+// along with the index of the legend item (or gradient stop) it matched, or -1
+type LegendClassifier interface {
+	Value(c [4]uint8) (float64, int, bool)
+	Items() []LegendItem
+}
+
+// LegendPalette is a discrete legend, where each pixel takes the value of the
+// perceptually nearest legend color if it is within tolerance
+type LegendPalette struct {
+	items  []LegendItem
+	labs   []Lab
+	values []*float64
+	// maximum CIEDE2000 distance between a pixel and its legend color
+	tolerance float64
+	// if set, the deprecated check that every RGBA channel differs by less than this is used instead of tolerance
+	channelTolerance int
+}
+
+// pixels within this CIEDE2000 distance of a legend color match it when no tolerance is given
+const defaultDeltaETolerance float64 = 10
+
+// newLegendPalette takes the CIEDE2000 tolerance if given, otherwise the
+// deprecated per channel colorTolerance that predates it, otherwise the default
+func newLegendPalette(legend []LegendItem, deltaETolerance *float64, channelTolerance int) (LegendPalette, error) {
+	palette := LegendPalette{items: legend, tolerance: defaultDeltaETolerance}
+	if deltaETolerance != nil {
+		if *deltaETolerance <= 0 {
+			return LegendPalette{}, fmt.Errorf("delta E tolerance must be positive")
+		}
+		palette.tolerance = *deltaETolerance
+	} else if channelTolerance > 0 {
+		palette.channelTolerance = channelTolerance
+	}
+
+	for _, legendItem := range legend {
+		palette.labs = append(palette.labs, rgbToLab(legendItem.Color[0], legendItem.Color[1], legendItem.Color[2]))
+		palette.values = append(palette.values, legendItem.Value)
+	}
+
+	return palette, nil
+}
+
+func (p LegendPalette) Items() []LegendItem {
+	return p.items
+}
+
+func (p LegendPalette) Value(c [4]uint8) (float64, int, bool) {
+	if c[3] < 128 {
+		return 0, -1, false
+	}
+
+	lab := rgbToLab(c[0], c[1], c[2])
+
+	bestLegendItemI := -1
+	bestDistance := math.MaxFloat64
+	for i, legendLab := range p.labs {
+		if p.channelTolerance > 0 && !isWithinChannelTolerance(c, p.items[i].Color, p.channelTolerance) {
+			continue
+		}
+
+		distance := deltaE2000(lab, legendLab)
+		if distance < bestDistance {
+			bestDistance = distance
+			bestLegendItemI = i
+		}
+	}
+
+	if bestLegendItemI == -1 || (p.channelTolerance == 0 && bestDistance > p.tolerance) {
+		return 0, -1, false
+	}
+
+	// legend colors without a value (e.g. "no data") still claim their pixels, so they aren't given to a similar color
+	if p.values[bestLegendItemI] == nil {
+		return 0, bestLegendItemI, false
+	}
+
+	return *p.values[bestLegendItemI], bestLegendItemI, true
+}
+
+func isWithinChannelTolerance(a, b [4]uint8, tolerance int) bool {
+	for i := range a {
+		diff := int(a[i]) - int(b[i])
+		if diff >= tolerance || -diff >= tolerance {
+			return false
+		}
+	}
+
+	return true
+}
+
+type GradientStop struct {
+	Color [4]uint8 `json:"color"`
+	Value float64  `json:"value"`
+}
+
+// GradientRamp is a continuous legend, a polyline through its color stops in CIELAB
+type GradientRamp struct {
+	stops  []GradientStop
+	labs   []Lab
+	values []float64
+	// pixels further than this CIEDE2000 distance from every point on the ramp have no value
+	maxDistance float64
+}
+
+func newGradientRamp(stops []GradientStop, maxDistance float64) (GradientRamp, error) {
+	if len(stops) < 2 {
+		return GradientRamp{}, fmt.Errorf("gradient legend needs at least 2 color stops, got %d", len(stops))
+	}
+
+	if maxDistance <= 0 {
+		return GradientRamp{}, fmt.Errorf("gradient max color distance must be positive")
+	}
+
+	ramp := GradientRamp{stops: stops, maxDistance: maxDistance}
+	for _, stop := range stops {
+		ramp.labs = append(ramp.labs, rgbToLab(stop.Color[0], stop.Color[1], stop.Color[2]))
+		ramp.values = append(ramp.values, stop.Value)
+	}
+
+	return ramp, nil
+}
+
+func (r GradientRamp) Items() []LegendItem {
+	items := []LegendItem{}
+	for _, stop := range r.stops {
+		value := stop.Value
+		items = append(items, LegendItem{Color: stop.Color, Value: &value})
+	}
+
+	return items
+}
+
+// Value projects the color onto the closest segment of the ramp and interpolates
+// between that segment's stop values, reporting the nearer of the two stops as the match
+func (r GradientRamp) Value(c [4]uint8) (float64, int, bool) {
+	// mostly transparent pixels are background rather than part of the ramp
+	if c[3] < 128 {
+		return 0, -1, false
+	}
+
+	lab := rgbToLab(c[0], c[1], c[2])
+
+	bestDistance := math.MaxFloat64
+	bestProjected := Lab{}
+	bestValue := 0.0
+	bestStopI := -1
+	for i := range len(r.labs) - 1 {
+		from, to := r.labs[i], r.labs[i+1]
+
+		segmentLenSq := 0.0
+		dot := 0.0
+		for ch := range 3 {
+			segmentLenSq += (to[ch] - from[ch]) * (to[ch] - from[ch])
+			dot += (lab[ch] - from[ch]) * (to[ch] - from[ch])
+		}
+
+		t := 0.0
+		if segmentLenSq > 0 {
+			t = math.Max(0, math.Min(1, dot/segmentLenSq))
+		}
+
+		projected := Lab{}
+		for ch := range 3 {
+			projected[ch] = from[ch] + t*(to[ch]-from[ch])
+		}
+
+		distance := labDistance(lab, projected)
+		if distance < bestDistance {
+			bestDistance = distance
+			bestProjected = projected
+			bestValue = r.values[i] + t*(r.values[i+1]-r.values[i])
+			bestStopI = i
+			if t > 0.5 {
+				bestStopI = i + 1
+			}
+		}
+	}
+
+	// the closest point is found euclidean in CIELAB, but the cutoff is perceptual like for discrete legends
+	bestDistance = deltaE2000(lab, bestProjected)
+
+	if bestDistance > r.maxDistance {
+		return 0, -1, false
+	}
+
+	return bestValue, bestStopI, true
+}
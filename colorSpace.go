@@ -0,0 +1,123 @@
+package main
+
+import "math"
+
+type Lab [3]float64
+
+// D65 reference white
+const (
+	labWhiteX float64 = 0.95047
+	labWhiteY float64 = 1.0
+	labWhiteZ float64 = 1.08883
+)
+
+func rgbToLab(r, g, b uint8) Lab {
+	lr, lg, lb := srgbToLinear(r), srgbToLinear(g), srgbToLinear(b)
+
+	x := (0.4124564*lr + 0.3575761*lg + 0.1804375*lb) / labWhiteX
+	y := (0.2126729*lr + 0.7151522*lg + 0.0721750*lb) / labWhiteY
+	z := (0.0193339*lr + 0.1191920*lg + 0.9503041*lb) / labWhiteZ
+
+	fx, fy, fz := labF(x), labF(y), labF(z)
+
+	return Lab{116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)}
+}
+
+func srgbToLinear(c uint8) float64 {
+	v := float64(c) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func labF(t float64) float64 {
+	const epsilon = 216.0 / 24389.0
+	const kappa = 24389.0 / 27.0
+
+	if t > epsilon {
+		return math.Cbrt(t)
+	}
+
+	return (kappa*t + 16) / 116
+}
+
+func labDistance(x, y Lab) float64 {
+	dl, da, db := x[0]-y[0], x[1]-y[1], x[2]-y[2]
+	return math.Sqrt(dl*dl + da*da + db*db)
+}
+
+// deltaE2000 is the CIEDE2000 color difference, following Sharma, Wu and Dalal's
+// "The CIEDE2000 Color-Difference Formula: Implementation Notes" (2005)
+func deltaE2000(x, y Lab) float64 {
+	l1, a1, b1 := x[0], x[1], x[2]
+	l2, a2, b2 := y[0], y[1], y[2]
+
+	cBar := (math.Hypot(a1, b1) + math.Hypot(a2, b2)) / 2
+	cBar7 := math.Pow(cBar, 7)
+	g := 0.5 * (1 - math.Sqrt(cBar7/(cBar7+math.Pow(25, 7))))
+
+	a1p, a2p := (1+g)*a1, (1+g)*a2
+	c1p, c2p := math.Hypot(a1p, b1), math.Hypot(a2p, b2)
+	h1p, h2p := labHueDeg(a1p, b1), labHueDeg(a2p, b2)
+
+	dLp := l2 - l1
+	dCp := c2p - c1p
+
+	dhp := 0.0
+	if c1p*c2p != 0 {
+		dhp = h2p - h1p
+		if dhp > 180 {
+			dhp -= 360
+		} else if dhp < -180 {
+			dhp += 360
+		}
+	}
+	dHp := 2 * math.Sqrt(c1p*c2p) * math.Sin(degToRad(dhp/2))
+
+	lBarP := (l1 + l2) / 2
+	cBarP := (c1p + c2p) / 2
+
+	hBarP := h1p + h2p
+	if c1p*c2p != 0 {
+		if math.Abs(h1p-h2p) <= 180 {
+			hBarP /= 2
+		} else if h1p+h2p < 360 {
+			hBarP = (hBarP + 360) / 2
+		} else {
+			hBarP = (hBarP - 360) / 2
+		}
+	}
+
+	t := 1 - 0.17*math.Cos(degToRad(hBarP-30)) +
+		0.24*math.Cos(degToRad(2*hBarP)) +
+		0.32*math.Cos(degToRad(3*hBarP+6)) -
+		0.20*math.Cos(degToRad(4*hBarP-63))
+
+	dTheta := 30 * math.Exp(-math.Pow((hBarP-275)/25, 2))
+	cBarP7 := math.Pow(cBarP, 7)
+	rc := 2 * math.Sqrt(cBarP7/(cBarP7+math.Pow(25, 7)))
+	lBarPMinus50Sq := (lBarP - 50) * (lBarP - 50)
+	sl := 1 + 0.015*lBarPMinus50Sq/math.Sqrt(20+lBarPMinus50Sq)
+	sc := 1 + 0.045*cBarP
+	sh := 1 + 0.015*cBarP*t
+	rt := -math.Sin(degToRad(2*dTheta)) * rc
+
+	lTerm, cTerm, hTerm := dLp/sl, dCp/sc, dHp/sh
+
+	return math.Sqrt(lTerm*lTerm + cTerm*cTerm + hTerm*hTerm + rt*cTerm*hTerm)
+}
+
+func labHueDeg(a, b float64) float64 {
+	if a == 0 && b == 0 {
+		return 0
+	}
+
+	h := radToDeg(math.Atan2(b, a))
+	if h < 0 {
+		h += 360
+	}
+
+	return h
+}
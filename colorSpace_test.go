@@ -0,0 +1,119 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// the test data of Sharma, Wu and Dalal (2005), table 1
+func TestDeltaE2000SharmaReferencePairs(t *testing.T) {
+	tests := []struct {
+		x, y Lab
+		want float64
+	}{
+		{Lab{50.0000, 2.6772, -79.7751}, Lab{50.0000, 0.0000, -82.7485}, 2.0425},
+		{Lab{50.0000, 3.1571, -77.2803}, Lab{50.0000, 0.0000, -82.7485}, 2.8615},
+		{Lab{50.0000, 2.8361, -74.0200}, Lab{50.0000, 0.0000, -82.7485}, 3.4412},
+		{Lab{50.0000, -1.3802, -84.2814}, Lab{50.0000, 0.0000, -82.7485}, 1.0000},
+		{Lab{50.0000, -1.1848, -84.8006}, Lab{50.0000, 0.0000, -82.7485}, 1.0000},
+		{Lab{50.0000, -0.9009, -85.5211}, Lab{50.0000, 0.0000, -82.7485}, 1.0000},
+		{Lab{50.0000, 0.0000, 0.0000}, Lab{50.0000, -1.0000, 2.0000}, 2.3669},
+		{Lab{50.0000, -1.0000, 2.0000}, Lab{50.0000, 0.0000, 0.0000}, 2.3669},
+		{Lab{50.0000, 2.4900, -0.0010}, Lab{50.0000, -2.4900, 0.0009}, 7.1792},
+		{Lab{50.0000, 2.4900, -0.0010}, Lab{50.0000, -2.4900, 0.0010}, 7.1792},
+		{Lab{50.0000, 2.4900, -0.0010}, Lab{50.0000, -2.4900, 0.0011}, 7.2195},
+		{Lab{50.0000, 2.4900, -0.0010}, Lab{50.0000, -2.4900, 0.0012}, 7.2195},
+		{Lab{50.0000, -0.0010, 2.4900}, Lab{50.0000, 0.0009, -2.4900}, 4.8045},
+		{Lab{50.0000, -0.0010, 2.4900}, Lab{50.0000, 0.0010, -2.4900}, 4.8045},
+		{Lab{50.0000, -0.0010, 2.4900}, Lab{50.0000, 0.0011, -2.4900}, 4.7461},
+		{Lab{50.0000, 2.5000, 0.0000}, Lab{50.0000, 0.0000, -2.5000}, 4.3065},
+		{Lab{50.0000, 2.5000, 0.0000}, Lab{73.0000, 25.0000, -18.0000}, 27.1492},
+		{Lab{50.0000, 2.5000, 0.0000}, Lab{61.0000, -5.0000, 29.0000}, 22.8977},
+		{Lab{50.0000, 2.5000, 0.0000}, Lab{56.0000, -27.0000, -3.0000}, 31.9030},
+		{Lab{50.0000, 2.5000, 0.0000}, Lab{58.0000, 24.0000, 15.0000}, 19.4535},
+		{Lab{50.0000, 2.5000, 0.0000}, Lab{50.0000, 3.1736, 0.5854}, 1.0000},
+		{Lab{50.0000, 2.5000, 0.0000}, Lab{50.0000, 3.2972, 0.0000}, 1.0000},
+		{Lab{50.0000, 2.5000, 0.0000}, Lab{50.0000, 1.8634, 0.5757}, 1.0000},
+		{Lab{50.0000, 2.5000, 0.0000}, Lab{50.0000, 3.2592, 0.3350}, 1.0000},
+		{Lab{60.2574, -34.0099, 36.2677}, Lab{60.4626, -34.1751, 39.4387}, 1.2644},
+		{Lab{63.0109, -31.0961, -5.8663}, Lab{62.8187, -29.7946, -4.0864}, 1.2630},
+		{Lab{61.2901, 3.7196, -5.3901}, Lab{61.4292, 2.2480, -4.9620}, 1.8731},
+		{Lab{35.0831, -44.1164, 3.7933}, Lab{35.0232, -40.0716, 1.5901}, 1.8645},
+		{Lab{22.7233, 20.0904, -46.6940}, Lab{23.0331, 14.9730, -42.5619}, 2.0373},
+		{Lab{36.4612, 47.8580, 18.3852}, Lab{36.2715, 50.5065, 21.2231}, 1.4146},
+		{Lab{90.8027, -2.0831, 1.4410}, Lab{91.1528, -1.6435, 0.0447}, 1.4441},
+		{Lab{90.9257, -0.5406, -0.9208}, Lab{88.6381, -0.8985, -0.7239}, 1.5381},
+		{Lab{6.7747, -0.2908, -2.4247}, Lab{5.8714, -0.0985, -2.2286}, 0.6377},
+		{Lab{2.0776, 0.0795, -1.1350}, Lab{0.9033, -0.0636, -0.5514}, 0.9082},
+	}
+
+	for i, test := range tests {
+		got := deltaE2000(test.x, test.y)
+		if math.Abs(got-test.want) > 5e-5 {
+			t.Errorf("pair %d: deltaE2000(%v, %v) = %.4f, want %.4f", i+1, test.x, test.y, got, test.want)
+		}
+
+		if reversed := deltaE2000(test.y, test.x); math.Abs(reversed-got) > 1e-9 {
+			t.Errorf("pair %d: deltaE2000 is not symmetric, %v vs %v", i+1, got, reversed)
+		}
+	}
+}
+
+func TestRgbToLab(t *testing.T) {
+	tests := []struct {
+		r, g, b uint8
+		want    Lab
+	}{
+		{0, 0, 0, Lab{0, 0, 0}},
+		{255, 255, 255, Lab{100, 0, 0}},
+		{255, 0, 0, Lab{53.2408, 80.0925, 67.2032}},
+		{0, 255, 0, Lab{87.7347, -86.1827, 83.1793}},
+		{0, 0, 255, Lab{32.2970, 79.1875, -107.8602}},
+	}
+
+	for _, test := range tests {
+		got := rgbToLab(test.r, test.g, test.b)
+		for i := range got {
+			if math.Abs(got[i]-test.want[i]) > 0.01 {
+				t.Errorf("rgbToLab(%d, %d, %d) = %v, want %v", test.r, test.g, test.b, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestLegendPaletteTolerance(t *testing.T) {
+	low, high := 1.0, 2.0
+	legend := []LegendItem{{Color: [4]uint8{200, 30, 30, 255}, Value: &low}, {Color: [4]uint8{30, 30, 200, 255}, Value: &high}}
+
+	tests := []struct {
+		name             string
+		deltaETolerance  *float64
+		channelTolerance int
+		color            [4]uint8
+		want             int
+	}{
+		{"default delta E matches a close shade", nil, 0, [4]uint8{205, 35, 28, 255}, 0},
+		{"default delta E rejects a far color", nil, 0, [4]uint8{30, 200, 30, 255}, -1},
+		{"tight delta E rejects a close shade", ptr(0.5), 0, [4]uint8{205, 35, 28, 255}, -1},
+		{"legacy channel tolerance matches", nil, 10, [4]uint8{205, 35, 28, 255}, 0},
+		{"legacy channel tolerance is strict", nil, 5, [4]uint8{205, 35, 28, 255}, -1},
+		{"delta E wins over the legacy field", ptr(20.0), 1, [4]uint8{205, 35, 28, 255}, 0},
+		{"transparent pixels never match", nil, 0, [4]uint8{200, 30, 30, 0}, -1},
+	}
+
+	for _, test := range tests {
+		palette, err := newLegendPalette(legend, test.deltaETolerance, test.channelTolerance)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, got, _ := palette.Value(test.color); got != test.want {
+			t.Errorf("%s: matched legend item %d, want %d", test.name, got, test.want)
+		}
+	}
+
+	if _, err := newLegendPalette(legend, ptr(0.0), 0); err == nil {
+		t.Errorf("expected a zero delta E tolerance to be rejected")
+	}
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+type CsvRowError struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column"`
+	Value   string `json:"value"`
+	Message string `json:"message"`
+}
+
+func (e CsvRowError) Error() string {
+	if e.Column == "" {
+		return fmt.Sprintf("row %d: %s", e.Row, e.Message)
+	}
+
+	return fmt.Sprintf("row %d, column %s: failed to parse %q: %s", e.Row, e.Column, e.Value, e.Message)
+}
+
+type CsvReader struct {
+	reader      *csv.Reader
+	header      []string
+	rowNum      int
+	skipBadRows bool
+	rowErrors   []CsvRowError
+}
+
+// how many bytes are sniffed to detect the encoding and delimiter
+const csvSniffSize = 64 * 1024
+
+var csvDelimiterCandidates = []rune{',', ';', '\t', '|'}
+
+const maxCsvFileSize = 10_000_000
+
+// sizeLimitedReader fails once more than remaining bytes have been read, so
+// streaming a CSV keeps the same cap as reading it into memory did
+type sizeLimitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (r *sizeLimitedReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+
+	n, err := r.reader.Read(p)
+	r.remaining -= int64(n)
+	if r.remaining < 0 {
+		return 0, fmt.Errorf("max file size of 10MB exceeded")
+	}
+
+	return n, err
+}
+
+func newCsvReader(submittedFile io.Reader, skipBadRows bool) (*CsvReader, error) {
+	decoded, err := decodeCsvEncoding(&sizeLimitedReader{reader: submittedFile, remaining: maxCsvFileSize})
+	if err != nil {
+		return nil, err
+	}
+
+	bufReader := bufio.NewReaderSize(decoded, csvSniffSize)
+	sniffed, err := bufReader.Peek(csvSniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("failed to read CSV from file: %w", err)
+	}
+
+	csvReader := csv.NewReader(bufReader)
+	csvReader.Comma = detectCsvDelimiter(sniffed)
+	csvReader.FieldsPerRecord = -1
+	csvReader.LazyQuotes = true
+
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("read csv unexpectedly empty")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	return &CsvReader{
+		reader:      csvReader,
+		header:      header,
+		rowNum:      1,
+		skipBadRows: skipBadRows,
+	}, nil
+}
+
+// decodeCsvEncoding converts the file to UTF-8, honoring a UTF-8 or UTF-16 BOM
+// and otherwise falling back to Windows-1252 when the content isn't valid UTF-8,
+// which is what spreadsheet exports without a BOM almost always are.
+func decodeCsvEncoding(submittedFile io.Reader) (io.Reader, error) {
+	bufReader := bufio.NewReaderSize(submittedFile, csvSniffSize)
+	sniffed, err := bufReader.Peek(csvSniffSize)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, fmt.Errorf("failed to read CSV from file: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(sniffed, []byte{0xEF, 0xBB, 0xBF}):
+		bufReader.Discard(3)
+		return bufReader, nil
+	case bytes.HasPrefix(sniffed, []byte{0xFF, 0xFE}), bytes.HasPrefix(sniffed, []byte{0xFE, 0xFF}):
+		decoder := unicode.UTF16(unicode.LittleEndian, unicode.ExpectBOM).NewDecoder()
+		return transform.NewReader(bufReader, decoder), nil
+	}
+
+	// a multi-byte character may have been cut off at the end of the sniffed bytes
+	lastRuneStart := len(sniffed) - 1
+	for lastRuneStart > 0 && len(sniffed)-lastRuneStart < utf8.UTFMax && !utf8.RuneStart(sniffed[lastRuneStart]) {
+		lastRuneStart--
+	}
+	if lastRuneStart >= 0 && !utf8.FullRune(sniffed[lastRuneStart:]) {
+		sniffed = sniffed[:lastRuneStart]
+	}
+
+	if !utf8.Valid(sniffed) {
+		return transform.NewReader(bufReader, charmap.Windows1252.NewDecoder()), nil
+	}
+
+	return bufReader, nil
+}
+
+// detectCsvDelimiter picks the candidate delimiter that appears most often
+// outside of quotes in the header line
+func detectCsvDelimiter(sniffed []byte) rune {
+	counts := make(map[rune]int)
+	inQuotes := false
+	for _, r := range string(sniffed) {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if !inQuotes && (r == '\n' || r == '\r') {
+			break
+		} else if !inQuotes && slices.Contains(csvDelimiterCandidates, r) {
+			counts[r]++
+		}
+	}
+
+	best := ','
+	for _, candidate := range csvDelimiterCandidates {
+		if counts[candidate] > counts[best] {
+			best = candidate
+		}
+	}
+
+	return best
+}
+
+func (r *CsvReader) Header() []string {
+	return r.header
+}
+
+func (r *CsvReader) ColumnIndex(col string) int {
+	return slices.Index(r.header, strings.TrimSpace(col))
+}
+
+// Read returns the next data row, with short rows padded to the header length,
+// and io.EOF once the file is exhausted
+func (r *CsvReader) Read() ([]string, error) {
+	for {
+		row, err := r.reader.Read()
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+
+		r.rowNum++
+
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			if rowErr := r.RowError(CsvRowError{Message: parseErr.Err.Error()}); rowErr != nil {
+				return nil, rowErr
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV from file: %w", err)
+		}
+
+		if len(row) == 1 && strings.TrimSpace(row[0]) == "" {
+			continue
+		}
+
+		for len(row) < len(r.header) {
+			row = append(row, "")
+		}
+
+		return row, nil
+	}
+}
+
+// RowNum is the 1-based line number of the row last returned by Read, counting the header
+func (r *CsvReader) RowNum() int {
+	return r.rowNum
+}
+
+// RowError records a problem with the current row. It returns nil if bad rows
+// are being skipped, in which case the caller should move on to the next row,
+// otherwise it returns the error to abort with.
+func (r *CsvReader) RowError(rowErr CsvRowError) error {
+	rowErr.Row = r.rowNum
+	r.rowErrors = append(r.rowErrors, rowErr)
+
+	if r.skipBadRows {
+		return nil
+	}
+
+	return rowErr
+}
+
+func (r *CsvReader) RowErrors() []CsvRowError {
+	return r.rowErrors
+}
+
+// ParseFloat parses the given column of a row, recording a row error if it is not numeric
+func (r *CsvReader) ParseFloat(row []string, colI int) (float64, bool, error) {
+	return r.parseColumn(row, colI, parseLenientFloat)
+}
+
+// ParseCoordinate parses a latitude or longitude column, where a comma is always a decimal separator
+func (r *CsvReader) ParseCoordinate(row []string, colI int) (float64, bool, error) {
+	return r.parseColumn(row, colI, parseCoordinate)
+}
+
+func (r *CsvReader) parseColumn(row []string, colI int, parse func(string) (float64, error)) (float64, bool, error) {
+	val, err := parse(row[colI])
+	if err != nil {
+		rowErr := r.RowError(CsvRowError{Column: r.header[colI], Value: row[colI], Message: err.Error()})
+		return 0, false, rowErr
+	}
+
+	return val, true, nil
+}
+
+// parseCoordinate accepts a decimal degree with a "." or "," decimal separator.
+// Coordinates are never digit grouped, so unlike parseLenientFloat "40,123" is
+// 40.123, and anything with more than one separator is rejected rather than guessed at.
+func parseCoordinate(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty value")
+	}
+
+	if strings.Count(s, ",")+strings.Count(s, ".") > 1 {
+		return 0, fmt.Errorf("ambiguous coordinate, expected a single decimal separator")
+	}
+
+	for i, r := range s {
+		if !(r >= '0' && r <= '9') && r != '.' && r != ',' && !(i == 0 && (r == '-' || r == '+')) {
+			return 0, fmt.Errorf("unexpected character %q", r)
+		}
+	}
+
+	val, err := strconv.ParseFloat(strings.Replace(s, ",", ".", 1), 64)
+	if err != nil {
+		return 0, fmt.Errorf("not a number")
+	}
+
+	return val, nil
+}
+
+// parseLenientFloat accepts the numeric formats commonly found in exported
+// spreadsheets, such as "$450,000", "1.234,5", "12 %", " 3.5 " and "(1,200)"
+func parseLenientFloat(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, fmt.Errorf("empty value")
+	}
+
+	isNegative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		isNegative = true
+		s = s[1 : len(s)-1]
+	}
+
+	var cleaned strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9', r == '.', r == ',', r == '-', r == '+', r == 'e', r == 'E':
+			cleaned.WriteRune(r)
+		case r == '$', r == '€', r == '£', r == '¥', r == '%', r == '\'', r == ' ', r == '\u00a0', r == '\u202f':
+			// currency symbols, percent signs and digit group separators carry no value
+		default:
+			return 0, fmt.Errorf("unexpected character %q", r)
+		}
+	}
+
+	numStr := normalizeDecimalSeparators(cleaned.String())
+
+	val, err := strconv.ParseFloat(numStr, 64)
+	if err != nil {
+		return 0, fmt.Errorf("not a number")
+	}
+
+	if isNegative {
+		val = -val
+	}
+
+	return val, nil
+}
+
+// normalizeDecimalSeparators rewrites a number using either "," or "." as the
+// decimal separator into the "." form. When both appear the last one is the
+// decimal separator; a lone "," is treated as a decimal separator unless it
+// groups exactly three digits, e.g. "450,000" vs "3,5".
+func normalizeDecimalSeparators(s string) string {
+	lastComma := strings.LastIndex(s, ",")
+	lastDot := strings.LastIndex(s, ".")
+
+	switch {
+	case lastComma == -1:
+		return s
+	case lastDot > lastComma:
+		return strings.ReplaceAll(s, ",", "")
+	case lastDot != -1:
+		s = strings.ReplaceAll(s, ".", "")
+		return strings.Replace(s, ",", ".", 1)
+	case strings.Count(s, ",") > 1 || len(s)-lastComma-1 == 3:
+		return strings.ReplaceAll(s, ",", "")
+	default:
+		return strings.Replace(s, ",", ".", 1)
+	}
+}
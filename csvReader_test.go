@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseLenientFloat(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"42", 42, false},
+		{" 3.5 ", 3.5, false},
+		{"$450,000", 450000, false},
+		{"1,234,567.89", 1234567.89, false},
+		{"1.234,5", 1234.5, false},
+		{"3,5", 3.5, false},
+		{"12 %", 12, false},
+		{"(1,200)", -1200, false},
+		{"-7.25", -7.25, false},
+		{"1e3", 1000, false},
+		{"€ 1 234,50", 1234.5, false},
+		{"", 0, true},
+		{"   ", 0, true},
+		{"n/a", 0, true},
+		{"12abc", 0, true},
+		{"1.2.3", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseLenientFloat(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseLenientFloat(%q) error = %v, wantErr %v", test.in, err, test.wantErr)
+			continue
+		}
+
+		if !test.wantErr && got != test.want {
+			t.Errorf("parseLenientFloat(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestParseCoordinate(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    float64
+		wantErr bool
+	}{
+		{"40.123", 40.123, false},
+		{"40,123", 40.123, false},
+		{"-73,9857", -73.9857, false},
+		{" +12.5 ", 12.5, false},
+		{"0", 0, false},
+		{"1,234.5", 0, true},
+		{"40.123.4", 0, true},
+		{"40°", 0, true},
+		{"4-0", 0, true},
+		{"1e3", 0, true},
+		{"", 0, true},
+	}
+
+	for _, test := range tests {
+		got, err := parseCoordinate(test.in)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseCoordinate(%q) error = %v, wantErr %v", test.in, err, test.wantErr)
+			continue
+		}
+
+		if !test.wantErr && got != test.want {
+			t.Errorf("parseCoordinate(%q) = %v, want %v", test.in, got, test.want)
+		}
+	}
+}
+
+func TestDetectCsvDelimiter(t *testing.T) {
+	tests := []struct {
+		header string
+		want   rune
+	}{
+		{"name,value\n1,2", ','},
+		{"name;value;other\n1,5;2", ';'},
+		{"name\tvalue\n", '\t'},
+		{"\"a,b\"|c|d", '|'},
+		{"single", ','},
+	}
+
+	for _, test := range tests {
+		if got := detectCsvDelimiter([]byte(test.header)); got != test.want {
+			t.Errorf("detectCsvDelimiter(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+func TestCsvReaderRejectsOversizedFiles(t *testing.T) {
+	row := "place,1\n"
+	oversized := "name,value\n" + strings.Repeat(row, maxCsvFileSize/len(row)+1)
+
+	csvReader, err := newCsvReader(strings.NewReader(oversized), false)
+	if err == nil {
+		for err == nil {
+			_, err = csvReader.Read()
+		}
+	}
+
+	if err == io.EOF || !strings.Contains(err.Error(), "10MB") {
+		t.Errorf("expected the size cap to be hit, got %v", err)
+	}
+
+	csvReader, err = newCsvReader(bytes.NewReader([]byte("name,value\nplace,1\n")), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if row, err := csvReader.Read(); err != nil || row[0] != "place" {
+		t.Errorf("expected a small file to read, got %v, %v", row, err)
+	}
+}
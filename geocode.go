@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Geocoder interface {
+	Geocode(query string) (LatLong, bool)
+}
+
+type GazetteerPlace struct {
+	LatLong     LatLong
+	CountryCode string
+	Admin1Code  string
+	Population  int
+}
+
+// GazetteerGeocoder resolves place names offline against a GeoNames style
+// cities dump (e.g. cities15000.txt), tab separated with the columns
+// geonameid, name, asciiname, alternatenames, latitude, longitude, ...,
+// country code (8), ..., admin1 code (10), ..., population (14).
+type GazetteerGeocoder struct {
+	placesByName     map[string][]GazetteerPlace
+	nonAlphaNumRegex *regexp.Regexp
+	whitespaceRegex  *regexp.Regexp
+}
+
+const gazetteerFilePath = "./database/gazetteer.txt"
+
+type UnresolvedRow struct {
+	Row   int    `json:"row"`
+	Query string `json:"query"`
+}
+
+type CsvReport struct {
+	RowErrors      []CsvRowError   `json:"rowErrors"`
+	UnresolvedRows []UnresolvedRow `json:"unresolvedRows,omitempty"`
+}
+
+// the gazetteer is parsed once and reused until the file on disk changes
+var gazetteerCache struct {
+	sync.Mutex
+	geocoder *GazetteerGeocoder
+	modTime  time.Time
+}
+
+func getGazetteerGeocoder() (*GazetteerGeocoder, error) {
+	fileInfo, err := os.Stat(gazetteerFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gazetteer: %w", err)
+	}
+
+	gazetteerCache.Lock()
+	defer gazetteerCache.Unlock()
+
+	if gazetteerCache.geocoder != nil && gazetteerCache.modTime.Equal(fileInfo.ModTime()) {
+		return gazetteerCache.geocoder, nil
+	}
+
+	file, err := os.Open(gazetteerFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open gazetteer: %w", err)
+	}
+
+	defer file.Close()
+
+	geocoder, err := readGazetteer(file)
+	if err != nil {
+		return nil, err
+	}
+
+	gazetteerCache.geocoder = geocoder
+	gazetteerCache.modTime = fileInfo.ModTime()
+
+	return geocoder, nil
+}
+
+func readGazetteer(gazetteerFile io.Reader) (*GazetteerGeocoder, error) {
+	geocoder := &GazetteerGeocoder{
+		placesByName:     make(map[string][]GazetteerPlace),
+		nonAlphaNumRegex: regexp.MustCompile("[^a-zA-Z0-9 ]+"),
+		whitespaceRegex:  regexp.MustCompile("[ _-]+"),
+	}
+
+	scanner := bufio.NewScanner(gazetteerFile)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) < 15 {
+			return nil, fmt.Errorf("gazetteer line %d has %d fields, expected at least 15", lineNum, len(fields))
+		}
+
+		lat, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse gazetteer lat %s on line %d: %w", fields[4], lineNum, err)
+		}
+
+		long, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse gazetteer long %s on line %d: %w", fields[5], lineNum, err)
+		}
+
+		population, _ := strconv.Atoi(fields[14])
+
+		place := GazetteerPlace{
+			LatLong:     LatLong{Lat: lat, Long: long},
+			CountryCode: strings.ToLower(fields[8]),
+			Admin1Code:  strings.ToLower(fields[10]),
+			Population:  population,
+		}
+
+		names := []string{fields[1], fields[2]}
+		if fields[3] != "" {
+			names = append(names, strings.Split(fields[3], ",")...)
+		}
+
+		seen := make(map[string]bool)
+		for _, name := range names {
+			normalizedName := normalizeName(geocoder.nonAlphaNumRegex, geocoder.whitespaceRegex, name)
+			if normalizedName == "" || seen[normalizedName] {
+				continue
+			}
+
+			seen[normalizedName] = true
+			geocoder.placesByName[normalizedName] = append(geocoder.placesByName[normalizedName], place)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read gazetteer: %w", err)
+	}
+
+	return geocoder, nil
+}
+
+// Geocode resolves queries such as "Austin", "Austin, TX" or "Paris, FR". Any
+// parts after the first comma narrow the candidates by admin1 or country code,
+// and the most populous remaining candidate wins. A qualifier matching none of
+// the candidates, like a full state name, leaves the query unresolved rather
+// than guessing at a place it may not mean.
+func (g *GazetteerGeocoder) Geocode(query string) (LatLong, bool) {
+	parts := strings.Split(query, ",")
+	name := normalizeName(g.nonAlphaNumRegex, g.whitespaceRegex, parts[0])
+
+	candidates := g.placesByName[name]
+	for _, part := range parts[1:] {
+		qualifier := strings.ToLower(strings.TrimSpace(part))
+		if qualifier == "" {
+			continue
+		}
+
+		filtered := []GazetteerPlace{}
+		for _, candidate := range candidates {
+			if candidate.Admin1Code == qualifier || candidate.CountryCode == qualifier {
+				filtered = append(filtered, candidate)
+			}
+		}
+		candidates = filtered
+	}
+
+	if len(candidates) == 0 {
+		return LatLong{}, false
+	}
+
+	best := candidates[0]
+	for _, candidate := range candidates[1:] {
+		if candidate.Population > best.Population {
+			best = candidate
+		}
+	}
+
+	return best.LatLong, true
+}
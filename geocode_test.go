@@ -0,0 +1,88 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testGazetteer = "1\tAustin\tAustin\t\t30.27\t-97.74\tP\tPPLA\tUS\t\tTX\t\t\t\t950000\n" +
+	"2\tAustin\tAustin\t\t43.67\t-92.97\tP\tPPL\tUS\t\tMN\t\t\t\t25000\n" +
+	"3\tParis\tParis\tParigi,Paree\t48.85\t2.35\tP\tPPLC\tFR\t\t11\t\t\t\t2100000\n" +
+	"4\tParis\tParis\t\t33.66\t-95.56\tP\tPPL\tUS\t\tTX\t\t\t\t25000\n"
+
+func TestGazetteerGeocode(t *testing.T) {
+	geocoder, err := readGazetteer(strings.NewReader(testGazetteer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query string
+		want  LatLong
+		found bool
+	}{
+		{"Austin", LatLong{Lat: 30.27, Long: -97.74}, true},
+		{"austin, mn", LatLong{Lat: 43.67, Long: -92.97}, true},
+		{"Paris, TX", LatLong{Lat: 33.66, Long: -95.56}, true},
+		{"Paris, fr", LatLong{Lat: 48.85, Long: 2.35}, true},
+		{"Austin, TX, US", LatLong{Lat: 30.27, Long: -97.74}, true},
+		// only codes are in the gazetteer, so a full name can't be told apart from a wrong qualifier
+		{"Paris, Texas", LatLong{}, false},
+		{"Austin, CA", LatLong{}, false},
+		{"Parigi", LatLong{Lat: 48.85, Long: 2.35}, true},
+		{"Atlantis", LatLong{}, false},
+	}
+
+	for _, test := range tests {
+		got, found := geocoder.Geocode(test.query)
+		if found != test.found || got != test.want {
+			t.Errorf("Geocode(%q) = %v, %v, want %v, %v", test.query, got, found, test.want, test.found)
+		}
+	}
+}
+
+func TestGazetteerGeocoderIsCached(t *testing.T) {
+	chdirTemp(t)
+	if err := os.MkdirAll("./database", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(gazetteerFilePath, []byte(testGazetteer), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	first, err := getGazetteerGeocoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := getGazetteerGeocoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Errorf("expected the parsed gazetteer to be reused")
+	}
+
+	// replacing the file should reload it
+	if err := os.WriteFile(gazetteerFilePath, []byte(testGazetteer[:strings.Index(testGazetteer, "3\t")]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(gazetteerFilePath, later, later); err != nil {
+		t.Fatal(err)
+	}
+
+	third, err := getGazetteerGeocoder()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, found := third.Geocode("Paris"); found {
+		t.Errorf("expected the changed gazetteer to be reloaded")
+	}
+}
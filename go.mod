@@ -7,6 +7,11 @@ require (
 	github.com/gin-gonic/gin v1.10.0
 	github.com/lithammer/fuzzysearch v1.1.8
 	github.com/paulmach/orb v0.11.1
+	github.com/srwiley/oksvg v0.0.0-20221011165216-be6e8873101c
+	github.com/srwiley/rasterx v0.0.0-20220730225603-2ab79fcdd4ef
+	golang.org/x/image v0.24.0
+	golang.org/x/text v0.24.0
+	rsc.io/pdf v0.1.1
 )
 
 require (
@@ -34,7 +39,6 @@ require (
 	golang.org/x/crypto v0.37.0 // indirect
 	golang.org/x/net v0.39.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
-	golang.org/x/text v0.24.0 // indirect
 	google.golang.org/protobuf v1.36.6 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
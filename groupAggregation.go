@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const defaultCompromisePercent float64 = 25
+
+// aggregateGroupData aggregates the same tags under each person's weights and
+// blends the resulting maps by the group rule. Compromise cells are those every
+// person ranks near their top, rather than cells one person loves and another hates.
+func aggregateGroupData(request GroupAggregateDataRequest) (GroupAggregationResponse, error) {
+	var response GroupAggregationResponse
+
+	if err := validateWeightProfiles(request.Profiles, request.Tags); err != nil {
+		return response, err
+	}
+
+	switch request.GroupRule {
+	case "":
+		request.GroupRule = "average"
+	case "average", "minimum", "borda":
+	default:
+		return response, fmt.Errorf("unknown group rule %s, expected average, minimum or borda", request.GroupRule)
+	}
+
+	compromisePercent := request.CompromisePercent
+	if compromisePercent == 0 {
+		compromisePercent = defaultCompromisePercent
+	}
+	if compromisePercent <= 0 || compromisePercent > 100 {
+		return response, fmt.Errorf("compromise percent %f must be between 0 and 100", compromisePercent)
+	}
+
+	cells, err := scoreAggregationCells(request.AggregateDataRequest)
+	if err != nil {
+		return response, err
+	}
+
+	latLongs := make([]LatLong, len(cells.positions))
+	for i, cell := range cells.positions {
+		lat, long := getLatLong(cell.X, cell.Y, cells.gapX, cells.gapY, cells.overlayLatLongBounds)
+		latLongs[i] = LatLong{Lat: lat, Long: long}
+	}
+
+	groupValues := make([]float64, len(cells.positions))
+	if request.GroupRule == "minimum" {
+		for i := range groupValues {
+			groupValues[i] = math.Inf(1)
+		}
+	}
+	isCompromise := make([]bool, len(cells.positions))
+	for i := range isCompromise {
+		isCompromise[i] = true
+	}
+
+	response.Profiles = []ProfileAggregation{}
+	for _, profile := range request.Profiles {
+		rawWeights := make([]float64, len(cells.allResults))
+		for i, result := range cells.allResults {
+			tagInfo, _ := Find(cells.request.Tags, func(tag AggregateDataTagInfo) bool { return tag.Tag == result.Tag })
+			rawWeights[i] = tagInfo.Weight
+			if weight, found := profile.Weights[result.Tag]; found {
+				rawWeights[i] = weight
+			}
+		}
+
+		values := cells.Combine(normalizeWeights(rawWeights))
+		ranks := rankPercentiles(values)
+
+		profileData := []LatLongValue{}
+		for i, value := range values {
+			if value > 0 {
+				profileData = append(profileData, LatLongValue{latLongs[i].Lat, latLongs[i].Long, value})
+			}
+
+			switch request.GroupRule {
+			case "average":
+				groupValues[i] += value / float64(len(request.Profiles))
+			case "minimum":
+				groupValues[i] = math.Min(groupValues[i], value)
+			case "borda":
+				// a cell's borda points are the cells ranked below it, scaled to the fraction of cells
+				groupValues[i] += ranks[i] / float64(len(request.Profiles))
+			}
+
+			if ranks[i] < 1-compromisePercent/100 {
+				isCompromise[i] = false
+			}
+		}
+
+		response.Profiles = append(response.Profiles, ProfileAggregation{Name: profile.Name, AggregateData: profileData})
+	}
+
+	response.GroupData = []LatLongValue{}
+	response.CompromiseData = []LatLongValue{}
+	for i, value := range groupValues {
+		if value > 0 {
+			response.GroupData = append(response.GroupData, LatLongValue{latLongs[i].Lat, latLongs[i].Long, value})
+		}
+
+		if isCompromise[i] {
+			response.CompromiseData = append(response.CompromiseData, LatLongValue{latLongs[i].Lat, latLongs[i].Long, value})
+		}
+	}
+
+	response.Candidates, err = findCandidateAreas(cells.request, cells.CandidateCells(groupValues), cells.allResults, cells.gapX, cells.gapY, cells.overlayLatLongBounds)
+	if err != nil {
+		return response, err
+	}
+
+	response.UnknownData = cells.unknownData
+	response.GapX, response.GapY = cells.gapX, cells.gapY
+
+	return response, nil
+}
+
+// validateWeightProfiles checks each profile can be told apart and only weighs the request's tags,
+// since a misspelt tag would otherwise silently fall back to the tag's own weight
+func validateWeightProfiles(profiles []WeightProfile, tags []AggregateDataTagInfo) error {
+	if len(profiles) == 0 {
+		return fmt.Errorf("no weight profiles to blend")
+	}
+
+	names := map[string]bool{}
+	for _, profile := range profiles {
+		if profile.Name == "" {
+			return fmt.Errorf("every weight profile needs a name")
+		}
+		if names[profile.Name] {
+			return fmt.Errorf("weight profile %s is given more than once", profile.Name)
+		}
+		names[profile.Name] = true
+
+		for tag, weight := range profile.Weights {
+			if _, found := Find(tags, func(tagInfo AggregateDataTagInfo) bool { return tagInfo.Tag == tag }); !found {
+				return fmt.Errorf("weight profile %s weighs tag %s, which isn't in the request", profile.Name, tag)
+			}
+			if weight < 0 {
+				return fmt.Errorf("weight of tag %s for %s must not be negative", tag, profile.Name)
+			}
+		}
+	}
+
+	return nil
+}
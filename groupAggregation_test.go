@@ -0,0 +1,97 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestValidateWeightProfiles(t *testing.T) {
+	tags := []AggregateDataTagInfo{{Tag: "a", Weight: 1}, {Tag: "b", Weight: 1}}
+
+	tests := []struct {
+		name     string
+		profiles []WeightProfile
+		wantErr  string
+	}{
+		{"valid", []WeightProfile{{Name: "ana", Weights: map[string]float64{"a": 2}}, {Name: "ben"}}, ""},
+		{"no profiles", nil, "no weight profiles"},
+		{"empty name", []WeightProfile{{Name: ""}}, "needs a name"},
+		{"duplicate name", []WeightProfile{{Name: "ana"}, {Name: "ana"}}, "more than once"},
+		{"unknown tag", []WeightProfile{{Name: "ana", Weights: map[string]float64{"c": 1}}}, "tag c"},
+		{"negative weight", []WeightProfile{{Name: "ana", Weights: map[string]float64{"a": -1}}}, "negative"},
+	}
+
+	for _, test := range tests {
+		err := validateWeightProfiles(test.profiles, tags)
+		if test.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			}
+			continue
+		}
+
+		if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: error = %v, want one containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestAggregateGroupData(t *testing.T) {
+	chdirTemp(t)
+
+	// two cells, each loved by one profile and hated by the other
+	writeOverlayFixture(t, 2, 1, func(x, y int) bool { return true })
+	writeMapFixture(t, "groupA", 2, 1, func(x, y int) color.NRGBA { return valuePixel(uint8(255 * (1 - x))) })
+	writeMapFixture(t, "groupB", 2, 1, func(x, y int) color.NRGBA { return valuePixel(uint8(255 * x)) })
+
+	tests := []struct {
+		groupRule string
+		want      float64
+	}{
+		{"average", 0.5},
+		{"minimum", 0},
+	}
+
+	for _, test := range tests {
+		request := GroupAggregateDataRequest{
+			AggregateDataRequest: AggregateDataRequest{
+				Tags:         []AggregateDataTagInfo{{Tag: "groupA", IsHighGood: true, Weight: 1}, {Tag: "groupB", IsHighGood: true, Weight: 1}},
+				SamplingRate: 1,
+			},
+			Profiles: []WeightProfile{
+				{Name: "ana", Weights: map[string]float64{"groupB": 0}},
+				{Name: "ben", Weights: map[string]float64{"groupA": 0}},
+			},
+			GroupRule: test.groupRule,
+		}
+
+		response, err := aggregateGroupData(request)
+		if err != nil {
+			t.Errorf("%s: %v", test.groupRule, err)
+			continue
+		}
+
+		if len(response.Profiles) != 2 || len(response.Profiles[0].AggregateData) != 1 || len(response.Profiles[1].AggregateData) != 1 {
+			t.Errorf("%s: each profile should score only its own cell, got %+v", test.groupRule, response.Profiles)
+		}
+
+		if test.want == 0 {
+			if len(response.GroupData) != 0 {
+				t.Errorf("%s: group data %+v, want none above 0", test.groupRule, response.GroupData)
+			}
+			continue
+		}
+
+		if len(response.GroupData) != 2 {
+			t.Errorf("%s: got %d group cells, want 2", test.groupRule, len(response.GroupData))
+			continue
+		}
+		for _, cell := range response.GroupData {
+			if math.Abs(cell[2]-test.want) > 1e-9 {
+				t.Errorf("%s: group value %v, want %v", test.groupRule, cell[2], test.want)
+			}
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"net/http"
+
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+	_ "golang.org/x/image/webp"
+)
+
+const (
+	defaultSvgRasterWidth = 2000
+	maxRasterDimension    = 10_000
+)
+
+// decodeSubmittedImage sniffs the format of a submitted map image, decoding
+// PNG, JPEG, GIF and WebP directly, rasterizing SVG at the placement's raster
+// width and rendering the placement's page of a PDF at its DPI
+func decodeSubmittedImage(submittedFile io.Reader, placement ImagePlacement) (*image.RGBA, error) {
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(submittedFile); err != nil {
+		return nil, fmt.Errorf("oops on read sf")
+	}
+
+	contentType := http.DetectContentType(buf.Bytes())
+	switch contentType {
+	case "image/png", "image/jpeg", "image/gif", "image/webp":
+		// the header declares the size, so a small file can't make decoding allocate more than the cap
+		config, _, err := image.DecodeConfig(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s image header: %w", contentType, err)
+		}
+		if config.Width > maxRasterDimension || config.Height > maxRasterDimension {
+			return nil, fmt.Errorf("%s image size %dx%d exceeds %d pixels per side", contentType, config.Width, config.Height, maxRasterDimension)
+		}
+
+		submittedImg, _, err := image.Decode(&buf)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode %s image: %w", contentType, err)
+		}
+
+		return decodeToRGBA(submittedImg), nil
+	case "application/pdf":
+		return renderPdfPage(buf.Bytes(), placement.PdfPage, placement.PdfDpi)
+	}
+
+	// svg has no magic number and sniffs as xml or plain text
+	if bytes.Contains(buf.Bytes()[:min(buf.Len(), 4096)], []byte("<svg")) {
+		return rasterizeSvg(&buf, placement.RasterWidth)
+	}
+
+	return nil, fmt.Errorf("unsupported image type %s, expected PNG, JPEG, GIF, WebP, SVG or PDF", contentType)
+}
+
+func rasterizeSvg(svgFile io.Reader, rasterWidth int) (*image.RGBA, error) {
+	icon, err := oksvg.ReadIconStream(svgFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse svg: %w", err)
+	}
+
+	if icon.ViewBox.W <= 0 || icon.ViewBox.H <= 0 {
+		return nil, fmt.Errorf("svg has no usable viewBox or size")
+	}
+
+	if rasterWidth == 0 {
+		rasterWidth = defaultSvgRasterWidth
+	}
+
+	width := rasterWidth
+	height := int(math.Round(float64(rasterWidth) * icon.ViewBox.H / icon.ViewBox.W))
+	if width <= 0 || height <= 0 || width > maxRasterDimension || height > maxRasterDimension {
+		return nil, fmt.Errorf("svg raster size %dx%d must be positive and at most %d pixels per side", width, height, maxRasterDimension)
+	}
+
+	icon.SetTarget(0, 0, float64(width), float64(height))
+
+	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
+	scanner := rasterx.NewScannerGV(width, height, rgba, rgba.Bounds())
+	icon.Draw(rasterx.NewDasher(width, height, scanner), 1.0)
+
+	return rgba, nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// a 1x1 transparent lossless WebP, since there's no WebP encoder to make one
+const testWebp = "RIFF\x1a\x00\x00\x00WEBPVP8L\x0d\x00\x00\x00\x2f\x00\x00\x00\x10\x07\x10\x11\x11\x88\x88\xfe\x07\x00"
+
+func TestDecodeSubmittedImage(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+
+	source := image.NewRGBA(image.Rect(0, 0, 4, 2))
+	for y := range 2 {
+		for x := range 4 {
+			source.SetRGBA(x, y, red)
+		}
+	}
+
+	encode := func(encoder func(*bytes.Buffer) error) []byte {
+		var b bytes.Buffer
+		if err := encoder(&b); err != nil {
+			t.Fatal(err)
+		}
+		return b.Bytes()
+	}
+	pngBytes := encode(func(b *bytes.Buffer) error { return png.Encode(b, source) })
+	jpegBytes := encode(func(b *bytes.Buffer) error { return jpeg.Encode(b, source, &jpeg.Options{Quality: 100}) })
+	gifBytes := encode(func(b *bytes.Buffer) error { return gif.Encode(b, source, nil) })
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 5"><rect width="10" height="5" fill="#ff0000"/></svg>`
+	pdfBytes := buildTestPdf(4, 2, "<< >>", "1 0 0 rg 0 0 4 2 re f")
+
+	tests := []struct {
+		name          string
+		file          []byte
+		placement     ImagePlacement
+		width, height int
+		want          color.RGBA
+	}{
+		{"png", pngBytes, ImagePlacement{}, 4, 2, red},
+		{"jpeg", jpegBytes, ImagePlacement{}, 4, 2, red},
+		{"gif", gifBytes, ImagePlacement{}, 4, 2, red},
+		{"webp", []byte(testWebp), ImagePlacement{}, 1, 1, color.RGBA{}},
+		{"svg at its raster width", []byte(svg), ImagePlacement{RasterWidth: 20}, 20, 10, red},
+		{"pdf at its dpi", pdfBytes, ImagePlacement{PdfDpi: 72}, 4, 2, red},
+	}
+
+	for _, test := range tests {
+		img, err := decodeSubmittedImage(bytes.NewReader(test.file), test.placement)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if size := img.Bounds().Size(); size.X != test.width || size.Y != test.height {
+			t.Errorf("%s: size %v, want %dx%d", test.name, size, test.width, test.height)
+			continue
+		}
+
+		if got := img.RGBAAt(0, 0); colorDistance(got, test.want) > 2 {
+			t.Errorf("%s: pixel %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestDecodeSubmittedImageRejectsOversizeImages(t *testing.T) {
+	var pngBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, image.NewRGBA(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+
+	// the IHDR chunk declares the size, followed by a checksum of its type and data
+	oversizePng := pngBuf.Bytes()
+	binary.BigEndian.PutUint32(oversizePng[16:20], maxRasterDimension+1)
+	binary.BigEndian.PutUint32(oversizePng[29:33], crc32.ChecksumIEEE(oversizePng[12:29]))
+
+	var gifBuf bytes.Buffer
+	if err := gif.Encode(&gifBuf, image.NewPaletted(image.Rect(0, 0, 1, 1), color.Palette{color.Black}), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the logical screen size follows the signature
+	oversizeGif := gifBuf.Bytes()
+	binary.LittleEndian.PutUint16(oversizeGif[8:10], maxRasterDimension+1)
+
+	// the VP8L header packs the width less 1 into its first 14 bits, here 16384
+	oversizeWebp := []byte(testWebp)
+	oversizeWebp[21] = 0xff
+	oversizeWebp[22] |= 0x3f
+
+	tests := []struct {
+		name      string
+		file      []byte
+		placement ImagePlacement
+	}{
+		{"png", oversizePng, ImagePlacement{}},
+		{"gif", oversizeGif, ImagePlacement{}},
+		{"webp", oversizeWebp, ImagePlacement{}},
+		{"svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 10 10"></svg>`), ImagePlacement{RasterWidth: maxRasterDimension + 1}},
+		{"pdf", buildTestPdf(4, 2, "<< >>", ""), ImagePlacement{PdfDpi: 72 * maxRasterDimension}},
+	}
+
+	for _, test := range tests {
+		if _, err := decodeSubmittedImage(bytes.NewReader(test.file), test.placement); err == nil || !strings.Contains(err.Error(), "pixels per side") {
+			t.Errorf("%s: error = %v, want the size rejected", test.name, err)
+		}
+	}
+
+	if _, err := decodeSubmittedImage(strings.NewReader("name,value\n"), ImagePlacement{}); err == nil || !strings.Contains(err.Error(), "unsupported image type") {
+		t.Errorf("expected a csv to be rejected as an unsupported image type, got %v", err)
+	}
+}
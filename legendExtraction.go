@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+const (
+	defaultLegendColors    = 8
+	maxLegendColors        = 32
+	maxLegendSamples       = 50_000
+	legendKMeansIterations = 20
+)
+
+type legendSample struct {
+	lab   Lab
+	color [4]uint8
+}
+
+// extractLegend clusters the colors of the submitted image lying within the
+// overlay and returns the dominant ones, most common first, as legend candidates
+func extractLegend(submittedFile io.Reader, data ExtractLegendData) ([]LegendCandidate, error) {
+	numColors := data.NumColors
+	if numColors == 0 {
+		numColors = defaultLegendColors
+	}
+	if numColors < 1 || numColors > maxLegendColors {
+		return nil, fmt.Errorf("number of legend colors must be between 1 and %d", maxLegendColors)
+	}
+
+	overlayMapImg, overlayLatLongBounds, err := getOverlayData()
+	if err != nil {
+		return nil, err
+	}
+
+	overlayBounds := overlayMapImg.Bounds()
+
+	rgbaSubmittedImage, err := decodeSubmittedImage(submittedFile, data.ImagePlacement)
+	if err != nil {
+		return nil, err
+	}
+
+	submittedImgBounds := rgbaSubmittedImage.Bounds()
+
+	mapper, _, err := newSubmittedImageMapper(data.ImagePlacement, overlayBounds, overlayLatLongBounds)
+	if err != nil {
+		return nil, err
+	}
+
+	// sample on a regular stride so large overlays don't make clustering slow
+	numPixels := overlayBounds.Max.X * overlayBounds.Max.Y
+	stride := max(1, int(math.Sqrt(float64(numPixels)/maxLegendSamples)))
+
+	samples := []legendSample{}
+	for oy := 0; oy < overlayBounds.Max.Y; oy += stride {
+		for ox := 0; ox < overlayBounds.Max.X; ox += stride {
+			if !isWithinOverlay(overlayMapImg, ox, oy) {
+				continue
+			}
+
+			sx, sy := mapper.SourcePixel(ox, oy)
+			if sx < 0 || sx >= submittedImgBounds.Max.X || sy < 0 || sy >= submittedImgBounds.Max.Y {
+				continue
+			}
+
+			sr, sg, sb, sa := getRgba(rgbaSubmittedImage, sx, sy)
+			if sa < 128 {
+				continue
+			}
+
+			samples = append(samples, legendSample{lab: rgbToLab(sr, sg, sb), color: [4]uint8{sr, sg, sb, sa}})
+		}
+	}
+
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no opaque pixels of the submitted image lie within the overlay")
+	}
+
+	centroids := kMeansLab(samples, min(numColors, len(samples)))
+
+	// report each cluster by the average color of its members rather than its centroid, which may not be a real pixel color
+	counts := make([]int, len(centroids))
+	colorSums := make([][4]int, len(centroids))
+	for _, sample := range samples {
+		i := nearestCentroid(centroids, sample.lab)
+		counts[i]++
+		for ch := range 4 {
+			colorSums[i][ch] += int(sample.color[ch])
+		}
+	}
+
+	candidates := []LegendCandidate{}
+	for i := range centroids {
+		if counts[i] == 0 {
+			continue
+		}
+
+		var color [4]uint8
+		for ch := range 4 {
+			color[ch] = uint8(math.Round(float64(colorSums[i][ch]) / float64(counts[i])))
+		}
+
+		candidates = append(candidates, LegendCandidate{
+			Color:      color,
+			PixelCount: counts[i] * stride * stride,
+			Coverage:   float64(counts[i]) / float64(len(samples)),
+		})
+	}
+
+	slices.SortFunc(candidates, func(a, b LegendCandidate) int { return b.PixelCount - a.PixelCount })
+
+	return candidates, nil
+}
+
+// kMeansLab clusters the samples into k groups in CIELAB, seeded by k-means++
+// with a fixed seed so the same image always gives the same legend
+func kMeansLab(samples []legendSample, k int) []Lab {
+	rng := rand.New(rand.NewSource(1))
+
+	centroids := []Lab{samples[rng.Intn(len(samples))].lab}
+	distances := make([]float64, len(samples))
+	for len(centroids) < k {
+		total := 0.0
+		for i, sample := range samples {
+			d := labDistance(sample.lab, centroids[nearestCentroid(centroids, sample.lab)])
+			distances[i] = d * d
+			total += distances[i]
+		}
+
+		// every sample already coincides with a centroid
+		if total == 0 {
+			break
+		}
+
+		target := rng.Float64() * total
+		chosen := len(samples) - 1
+		for i, d := range distances {
+			target -= d
+			if target <= 0 {
+				chosen = i
+				break
+			}
+		}
+
+		centroids = append(centroids, samples[chosen].lab)
+	}
+
+	assignments := make([]int, len(samples))
+	for iteration := range legendKMeansIterations {
+		changed := false
+		for i, sample := range samples {
+			nearest := nearestCentroid(centroids, sample.lab)
+			if nearest != assignments[i] {
+				assignments[i] = nearest
+				changed = true
+			}
+		}
+
+		if !changed && iteration > 0 {
+			break
+		}
+
+		sums := make([]Lab, len(centroids))
+		counts := make([]int, len(centroids))
+		for i, sample := range samples {
+			for ch := range 3 {
+				sums[assignments[i]][ch] += sample.lab[ch]
+			}
+			counts[assignments[i]]++
+		}
+
+		for i := range centroids {
+			if counts[i] == 0 {
+				continue
+			}
+			for ch := range 3 {
+				centroids[i][ch] = sums[i][ch] / float64(counts[i])
+			}
+		}
+	}
+
+	return centroids
+}
+
+func nearestCentroid(centroids []Lab, lab Lab) int {
+	best := 0
+	bestDistance := math.MaxFloat64
+	for i, centroid := range centroids {
+		distance := labDistance(lab, centroid)
+		if distance < bestDistance {
+			bestDistance = distance
+			best = i
+		}
+	}
+
+	return best
+}
@@ -0,0 +1,68 @@
+package main
+
+import "testing"
+
+func TestKMeansLab(t *testing.T) {
+	clusterCenters := []Lab{{30, 40, -20}, {60, -30, 50}, {90, 0, 5}}
+
+	var samples []legendSample
+	for i, center := range clusterCenters {
+		// a few hundred jittered samples per cluster, with cluster sizes differing
+		for j := range 100 * (i + 1) {
+			offset := float64(j%5) - 2
+			samples = append(samples, legendSample{lab: Lab{center[0] + offset, center[1] - offset, center[2] + offset/2}})
+		}
+	}
+
+	tests := []struct {
+		name string
+		k    int
+		want []Lab
+	}{
+		{"one cluster per color", 3, clusterCenters},
+		{"a single cluster is the mean", 1, []Lab{{70, -3.3333, 15.8333}}},
+	}
+
+	for _, test := range tests {
+		centroids := kMeansLab(samples, test.k)
+		if len(centroids) != len(test.want) {
+			t.Errorf("%s: got %d centroids, want %d", test.name, len(centroids), len(test.want))
+			continue
+		}
+
+		for _, want := range test.want {
+			nearest := centroids[nearestCentroid(centroids, want)]
+			if labDistance(nearest, want) > 0.01 {
+				t.Errorf("%s: no centroid near %v, got %v", test.name, want, centroids)
+			}
+		}
+	}
+}
+
+func TestKMeansLabStopsAtDistinctColors(t *testing.T) {
+	samples := []legendSample{{lab: Lab{50, 0, 0}}, {lab: Lab{50, 0, 0}}, {lab: Lab{20, 10, 10}}}
+
+	centroids := kMeansLab(samples, 5)
+	if len(centroids) != 2 {
+		t.Errorf("got %d centroids for 2 distinct colors, want 2", len(centroids))
+	}
+}
+
+func TestNearestCentroid(t *testing.T) {
+	centroids := []Lab{{0, 0, 0}, {50, 0, 0}, {100, 0, 0}}
+
+	tests := []struct {
+		lab  Lab
+		want int
+	}{
+		{Lab{10, 5, 5}, 0},
+		{Lab{49, 0, 0}, 1},
+		{Lab{80, 0, 0}, 2},
+	}
+
+	for _, test := range tests {
+		if got := nearestCentroid(centroids, test.lab); got != test.want {
+			t.Errorf("nearestCentroid(%v) = %d, want %d", test.lab, got, test.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/png"
@@ -9,6 +10,8 @@ import (
 	"mime/multipart"
 	"net/http"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
@@ -20,8 +23,13 @@ type LatLong struct {
 }
 
 type OverlayBounds struct {
-	TopLeft     LatLong `json:"topLeft"`
-	BottomRight LatLong `json:"bottomRight"`
+	TopLeft     LatLong         `json:"topLeft"`
+	BottomRight LatLong         `json:"bottomRight"`
+	Projection  *ProjectionInfo `json:"projection,omitempty"`
+	// resolved from Projection when the bounds are read, along with the projected corners
+	proj                               Projection
+	projTopLeftX, projTopLeftY         float64
+	projBottomRightX, projBottomRightY float64
 }
 
 type LegendItem struct {
@@ -29,15 +37,76 @@ type LegendItem struct {
 	Value *float64 `json:"value"`
 }
 
+// ImagePlacement locates a submitted image relative to the overlay, either by
+// the overlay's bounding box within the image or by control points. For SVG and
+// PDF, pixel locations refer to the image rasterized at RasterWidth or PdfDpi.
+type ImagePlacement struct {
+	OverlayLocTopLeftX     int             `json:"overlayLocTopLeftX"`
+	OverlayLocTopLeftY     int             `json:"overlayLocTopLeftY"`
+	OverlayLocBottomRightX int             `json:"overlayLocBottomRightX"`
+	OverlayLocBottomRightY int             `json:"overlayLocBottomRightY"`
+	ControlPoints          []ControlPoint  `json:"controlPoints"`
+	RegistrationTransform  string          `json:"registrationTransform"`
+	Projection             *ProjectionInfo `json:"projection"`
+	RasterWidth            int             `json:"rasterWidth"`
+	PdfPage                int             `json:"pdfPage"`
+	PdfDpi                 float64         `json:"pdfDpi"`
+}
+
 type SubmitChoroplethMapData struct {
-	Tag                    string       `json:"tag"`
-	OverlayLocTopLeftX     int          `json:"overlayLocTopLeftX"`
-	OverlayLocTopLeftY     int          `json:"overlayLocTopLeftY"`
-	OverlayLocBottomRightX int          `json:"overlayLocBottomRightX"`
-	OverlayLocBottomRightY int          `json:"overlayLocBottomRightY"`
-	ColorTolerance         int          `json:"colorTolerance"`
-	BorderTolerance        int          `json:"borderTolerance"`
-	Legend                 []LegendItem `json:"legend"`
+	ImagePlacement
+	Tag             string   `json:"tag"`
+	DeltaETolerance *float64 `json:"deltaETolerance"`
+	// deprecated: the maximum difference of each RGBA channel, only used when deltaETolerance isn't given
+	ColorTolerance      int            `json:"colorTolerance"`
+	BorderTolerance     int            `json:"borderTolerance"`
+	CleanupMode         string         `json:"cleanupMode"`
+	CleanupRadius       int            `json:"cleanupRadius"`
+	Legend              []LegendItem   `json:"legend"`
+	LegendMode          string         `json:"legendMode"`
+	GradientStops       []GradientStop `json:"gradientStops"`
+	MaxGradientDistance float64        `json:"maxGradientDistance"`
+	Diagnostics         bool           `json:"diagnostics"`
+}
+
+type LegendItemCoverage struct {
+	Color      [4]uint8 `json:"color"`
+	Value      *float64 `json:"value"`
+	PixelCount int      `json:"pixelCount"`
+	Coverage   float64  `json:"coverage"`
+}
+
+// ChoroplethDiagnostics describes how well a submitted image's colors matched
+// its legend, with counts over overlay pixels. Filled pixels matched nothing
+// but were given a value by annotation cleanup.
+// SubmissionReport describes how a submitted map was turned into its preview, with only
+// the parts that apply to the kind of submission set
+type SubmissionReport struct {
+	RegistrationReport *RegistrationReport    `json:"registrationReport,omitempty"`
+	Diagnostics        *ChoroplethDiagnostics `json:"diagnostics,omitempty"`
+	CsvReport          *CsvReport             `json:"csvReport,omitempty"`
+}
+
+type ChoroplethDiagnostics struct {
+	OverlayPixels   int                  `json:"overlayPixels"`
+	MatchedPixels   int                  `json:"matchedPixels"`
+	FilledPixels    int                  `json:"filledPixels"`
+	UnmatchedPixels int                  `json:"unmatchedPixels"`
+	Coverage        float64              `json:"coverage"`
+	LegendItems     []LegendItemCoverage `json:"legendItems"`
+	// highlights filled and unmatched pixels, only produced when requested
+	layer *image.RGBA
+}
+
+type ExtractLegendData struct {
+	ImagePlacement
+	NumColors int `json:"numColors"`
+}
+
+type LegendCandidate struct {
+	Color      [4]uint8 `json:"color"`
+	PixelCount int      `json:"pixelCount"`
+	Coverage   float64  `json:"coverage"`
 }
 
 type SubmitChoroplethMapFromCsvData struct {
@@ -49,6 +118,7 @@ type SubmitChoroplethMapFromCsvData struct {
 	UpperBoundThreshold       float64 `json:"upperBoundThreshold"`
 	AllowNameMatchingLeniency bool    `json:"allowNameMatchingLeniency"`
 	SkipMissing               bool    `json:"skipMissing"`
+	SkipBadRows               bool    `json:"skipBadRows"`
 }
 
 type SubmitFileData struct {
@@ -57,17 +127,28 @@ type SubmitFileData struct {
 }
 
 type SubmitPointsOfInterestFromCsvData struct {
-	Tag                     string  `json:"tag"`
-	MinThresholdRadiusMiles float64 `json:"minThresholdRadiusMiles"`
-	MaxThresholdRadiusMiles float64 `json:"maxThresholdRadiusMiles"`
-	LatCol                  string  `json:"latCol"`
-	LongCol                 string  `json:"longCol"`
-	WeightCol               *string `json:"weightCol"`
+	Tag                     string             `json:"tag"`
+	MinThresholdRadiusMiles float64            `json:"minThresholdRadiusMiles"`
+	MaxThresholdRadiusMiles float64            `json:"maxThresholdRadiusMiles"`
+	LatCol                  string             `json:"latCol"`
+	LongCol                 string             `json:"longCol"`
+	WeightCol               *string            `json:"weightCol"`
+	WeightCategoryCol       *string            `json:"weightCategoryCol"`
+	WeightByCategory        map[string]float64 `json:"weightByCategory"`
+	WeightNormalization     string             `json:"weightNormalization"`
+	WeightScale             *float64           `json:"weightScale"`
+	MinRadiusCol            *string            `json:"minRadiusCol"`
+	MaxRadiusCol            *string            `json:"maxRadiusCol"`
+	AddressCol              *string            `json:"addressCol"`
+	SkipUnresolved          bool               `json:"skipUnresolved"`
+	SkipBadRows             bool               `json:"skipBadRows"`
 }
 
 type PointOfInterest struct {
-	LatLong LatLong `json:"latLong"`
-	Weight  float64 `json:"weight"`
+	LatLong                 LatLong  `json:"latLong"`
+	Weight                  *float64 `json:"weight"`
+	MinThresholdRadiusMiles *float64 `json:"minThresholdRadiusMiles"`
+	MaxThresholdRadiusMiles *float64 `json:"maxThresholdRadiusMiles"`
 }
 
 type SubmitPointsOfInterestData struct {
@@ -77,6 +158,13 @@ type SubmitPointsOfInterestData struct {
 	MaxThresholdRadiusMiles float64           `json:"maxThresholdRadiusMiles"`
 }
 
+type SubmitZoneMaskData struct {
+	Tag              string             `json:"tag"`
+	CategoryProperty string             `json:"categoryProperty"`
+	CategoryScores   map[string]float64 `json:"categoryScores"`
+	DefaultScore     *float64           `json:"defaultScore"`
+}
+
 type ConfirmMapData struct {
 	Tag string `json:"tag"`
 }
@@ -85,11 +173,30 @@ type AggregateDataTagInfo struct {
 	Tag        string  `json:"tag"`
 	IsHighGood bool    `json:"isHighGood"`
 	Weight     float64 `json:"weight"`
+	// acceptable range of the tag's score, after accounting for isHighGood
+	MinScore *float64 `json:"minScore"`
+	MaxScore *float64 `json:"maxScore"`
 }
 
 type AggregateDataRequest struct {
 	Tags         []AggregateDataTagInfo `json:"tags"`
 	SamplingRate int                    `json:"samplingRate"`
+	// how cells without data in a dataset are scored: worst (the default), neutral, exclude or unknown
+	NoDataPolicy string `json:"noDataPolicy"`
+	// whether cells failing a tag's score constraints are dropped from the aggregate data (exclude, the default) or kept (flag)
+	ConstraintMode string `json:"constraintMode"`
+	// how scores are combined: arithmeticMean (the default), geometricMean, minimum, owa or topsis.
+	// minimum takes the lowest score of the tags weighted above 0, ignoring how large their weights are
+	Operator string `json:"operator"`
+	// for owa, the weight given to each tag's score by rank, highest score first, one per tag with a stored map
+	OwaWeights []float64 `json:"owaWeights"`
+	// number of best candidate areas to return, none when 0
+	TopN int `json:"topN"`
+	// how candidate areas are found: peaks (the default), the best cells at least minSeparationMiles apart,
+	// or regions, connected cells scoring at or above the regionPercentile of all cells
+	CandidateMode      string  `json:"candidateMode"`
+	MinSeparationMiles float64 `json:"minSeparationMiles"`
+	RegionPercentile   float64 `json:"regionPercentile"`
 }
 
 type LatLongValue = [3]float64
@@ -97,10 +204,179 @@ type LatLongValue = [3]float64
 type TaggedImageData struct {
 	Tag  string      `json:"tag"`
 	Data [][]float64 `json:"data"`
+	// cells where the dataset has no data, omitted when it has data everywhere
+	NoData [][]bool `json:"noData,omitempty"`
+	// unweighted scores, with cells without data scored by the no data policy
+	scores [][]float64
+	// the tag's share of the total weight
+	weight             float64
+	minScore, maxScore *float64
+}
+
+type CandidateArea struct {
+	Centroid    LatLong `json:"centroid"`
+	Peak        LatLong `json:"peak"`
+	AreaSqMiles float64 `json:"areaSqMiles"`
+	NumCells    int     `json:"numCells"`
+	MeanScore   float64 `json:"meanScore"`
+	PeakScore   float64 `json:"peakScore"`
+	// mean score of each tag over the area's cells with data
+	TagScores map[string]float64 `json:"tagScores"`
+}
+
+type DatasetInfo struct {
+	Width     int       `json:"width"`
+	Height    int       `json:"height"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type TagScoreBreakdown struct {
+	Tag string `json:"tag"`
+	// the stored value averaged over the sampled cell, before accounting for isHighGood
+	RawValue float64 `json:"rawValue"`
+	NoData   bool    `json:"noData"`
+	// the score used in aggregation, with no data scored by the no data policy
+	Score  float64 `json:"score"`
+	Weight float64 `json:"weight"`
+	// share of the total, only given for the arithmetic mean where shares add up
+	Contribution    *float64    `json:"contribution"`
+	MeetsConstraint bool        `json:"meetsConstraint"`
+	Dataset         DatasetInfo `json:"dataset"`
+}
+
+type ScoreBreakdown struct {
+	LatLong          LatLong             `json:"latLong"`
+	Total            float64             `json:"total"`
+	IsKnown          bool                `json:"isKnown"`
+	MeetsConstraints bool                `json:"meetsConstraints"`
+	Tags             []TagScoreBreakdown `json:"tags"`
+}
+
+type WeightRange struct {
+	Tag       string  `json:"tag"`
+	MinWeight float64 `json:"minWeight"`
+	MaxWeight float64 `json:"maxWeight"`
+}
+
+type SensitivityAnalysisRequest struct {
+	AggregateDataRequest
+	WeightRanges      []WeightRange `json:"weightRanges"`
+	SweepSteps        int           `json:"sweepSteps"`
+	MonteCarloSamples int           `json:"monteCarloSamples"`
+	TopPercent        float64       `json:"topPercent"`
+}
+
+// CellSensitivity describes how a cell's rank moves as weights are sampled, with
+// ranks as the fraction of cells scoring lower, so 1 is the best
+type CellSensitivity struct {
+	LatLong        LatLong `json:"latLong"`
+	BaseValue      float64 `json:"baseValue"`
+	BaseRank       float64 `json:"baseRank"`
+	MeanRank       float64 `json:"meanRank"`
+	RankStdDev     float64 `json:"rankStdDev"`
+	MinRank        float64 `json:"minRank"`
+	MaxRank        float64 `json:"maxRank"`
+	TopProbability float64 `json:"topProbability"`
+}
+
+type AreaSensitivity struct {
+	Area CandidateArea `json:"area"`
+	// how far the area's mean rank moves as each tag's weight is swept across its range
+	RankSwingByTag   map[string]float64 `json:"rankSwingByTag"`
+	MostSensitiveTag string             `json:"mostSensitiveTag"`
+}
+
+type SensitivityAnalysisResponse struct {
+	Cells []CellSensitivity `json:"cells"`
+	Areas []AreaSensitivity `json:"areas"`
+	GapY  float64           `json:"gapY"`
+	GapX  float64           `json:"gapX"`
+}
+
+type AhpWeightsRequest struct {
+	// tags to weigh, each once and with a stored map, in the order of the comparison matrix's rows and columns
+	Tags []AggregateDataTagInfo `json:"tags"`
+	// comparisons[i][j] is how many times more important tag i is than tag j on Saaty's 1 to 9 scale.
+	// entries below the diagonal may be left as 0 to take the reciprocal of their mirror, and the diagonal is always 1.
+	Comparisons [][]float64 `json:"comparisons"`
+}
+
+type AhpWeightsResponse struct {
+	// the request's tags with weights derived from the comparisons, summing to 1
+	Tags             []AggregateDataTagInfo `json:"tags"`
+	LambdaMax        float64                `json:"lambdaMax"`
+	ConsistencyIndex float64                `json:"consistencyIndex"`
+	ConsistencyRatio float64                `json:"consistencyRatio"`
+	IsConsistent     bool                   `json:"isConsistent"`
+	Warnings         []string               `json:"warnings"`
+}
+
+type WeightProfile struct {
+	Name string `json:"name"`
+	// weight of each of the request's tags for this person, defaulting to the tag's own weight
+	Weights map[string]float64 `json:"weights"`
+}
+
+type GroupAggregateDataRequest struct {
+	AggregateDataRequest
+	Profiles []WeightProfile `json:"profiles"`
+	// how profiles' maps are combined: average (the default), minimum or borda
+	GroupRule string `json:"groupRule"`
+	// compromise cells rank within this top percent for every profile
+	CompromisePercent float64 `json:"compromisePercent"`
+}
+
+type ProfileAggregation struct {
+	Name          string         `json:"name"`
+	AggregateData []LatLongValue `json:"aggregateData"`
+}
+
+type GroupAggregationResponse struct {
+	Profiles       []ProfileAggregation `json:"profiles"`
+	GroupData      []LatLongValue       `json:"groupData"`
+	CompromiseData []LatLongValue       `json:"compromiseData"`
+	Candidates     []CandidateArea      `json:"candidates"`
+	UnknownData    []LatLong            `json:"unknownData"`
+	GapY           float64              `json:"gapY"`
+	GapX           float64              `json:"gapX"`
+}
+
+type Scenario struct {
+	Name    string               `json:"name"`
+	Request AggregateDataRequest `json:"request"`
+	SavedAt time.Time            `json:"savedAt"`
+}
+
+// ComparisonSide is a saved scenario by name, or else an inline aggregation request
+type ComparisonSide struct {
+	Scenario string                `json:"scenario"`
+	Request  *AggregateDataRequest `json:"request"`
+}
+
+type CompareScenariosRequest struct {
+	A ComparisonSide `json:"a"`
+	B ComparisonSide `json:"b"`
+}
+
+type ScenarioComparison struct {
+	// b's value minus a's at every cell both aggregated
+	DifferenceData    []LatLongValue `json:"differenceData"`
+	EnteredTopDecile  []LatLong      `json:"enteredTopDecile"`
+	LeftTopDecile     []LatLong      `json:"leftTopDecile"`
+	RankCorrelation   float64        `json:"rankCorrelation"`
+	TopDecileOverlap  float64        `json:"topDecileOverlap"`
+	MeanAbsDifference float64        `json:"meanAbsDifference"`
+	GapY              float64        `json:"gapY"`
+	GapX              float64        `json:"gapX"`
 }
 
 type MapAggregationResponse struct {
-	AggregateData  []LatLongValue    `json:"aggregateData"`
+	AggregateData []LatLongValue `json:"aggregateData"`
+	UnknownData   []LatLong      `json:"unknownData"`
+	// cells failing any tag's score constraints
+	FailedConstraintData []LatLong `json:"failedConstraintData"`
+	// the best distinct areas, best first, when topN is requested
+	Candidates     []CandidateArea   `json:"candidates"`
 	ComponentsData []TaggedImageData `json:"componentsData"`
 	GapY           float64           `json:"gapY"`
 	GapX           float64           `json:"gapX"`
@@ -139,21 +415,82 @@ func main() {
 			return
 		}
 
-		newImg, err := submitChoroplethMap(file, submitMapData)
+		newImg, registrationReport, diagnostics, err := submitChoroplethMap(file, submitMapData)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, "Oops failed "+err.Error())
 			return
 		}
 
+		if diagnostics.layer != nil {
+			if _, err := writeTmpFile(diagnostics.layer, diagnosticLayerTag(submitMapData.Tag)); err != nil {
+				c.JSON(http.StatusBadRequest, "Oops failed "+err.Error())
+				return
+			}
+		}
+
 		tmpFilePath, err := writeTmpFile(newImg, submitMapData.Tag)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, "Oops failed "+err.Error())
 			return
 		}
 
+		if err := writeTmpReport(SubmissionReport{RegistrationReport: registrationReport, Diagnostics: &diagnostics}, submitMapData.Tag); err != nil {
+			c.JSON(http.StatusBadRequest, "Oops failed "+err.Error())
+			return
+		}
+
 		c.File(tmpFilePath)
 	})
 
+	// the report of the latest preview for the tag, kept out of the preview's headers
+	// since the client can't read those cross origin and they're limited in size
+	r.GET("/submission-report/:tag", func(c *gin.Context) {
+		report, err := readTmpReport(c.Param("tag"))
+		if errors.Is(err, os.ErrNotExist) {
+			c.JSON(http.StatusNotFound, "Oops no submission report for "+c.Param("tag"))
+			return
+		}
+
+		respond(c, report, err)
+	})
+
+	r.GET("/diagnostic-layer/:tag", func(c *gin.Context) {
+		layerPath := fmt.Sprintf("./tmp-database/%s.png", diagnosticLayerTag(c.Param("tag")))
+		if _, err := os.Stat(layerPath); err != nil {
+			c.JSON(http.StatusNotFound, "Oops no diagnostic layer for "+c.Param("tag"))
+			return
+		}
+
+		c.File(layerPath)
+	})
+
+	r.POST("/extract-legend", func(c *gin.Context) {
+		var fileData SubmitFileData
+
+		if err := c.ShouldBind(&fileData); err != nil {
+			c.JSON(http.StatusBadRequest, "Oops could not bind")
+			return
+		}
+
+		file, err := fileData.File.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, "Oops open file")
+			return
+		}
+
+		defer file.Close()
+
+		var extractLegendData ExtractLegendData
+		err = json.Unmarshal([]byte(fileData.Data), &extractLegendData)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, "Oops unmarshal "+err.Error())
+			return
+		}
+
+		candidates, err := extractLegend(file, extractLegendData)
+		respond(c, candidates, err)
+	})
+
 	r.GET("/submit-choropleth-map-from-csv", func(c *gin.Context) {
 		// form, err := c.MultipartForm()
 		// if err != nil {
@@ -194,9 +531,9 @@ func main() {
 			return
 		}
 
-		newImg, err := submitChoroplethMapFromCsv(geoJsonFile, locationCsvFile, submitMapData)
+		newImg, csvReport, err := submitChoroplethMapFromCsv(geoJsonFile, locationCsvFile, submitMapData)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, "Oops failed "+err.Error())
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error(), "csvReport": csvReport})
 			return
 		}
 
@@ -206,10 +543,18 @@ func main() {
 			return
 		}
 
+		if err := writeTmpReport(SubmissionReport{CsvReport: &csvReport}, submitMapData.Tag); err != nil {
+			c.JSON(http.StatusBadRequest, "Oops failed "+err.Error())
+			return
+		}
+
 		c.File(tmpFilePath)
 	})
 
 	r.POST("/submit-coordinates-from-csv", func(c *gin.Context) {
+		// leave some room over the CSV cap for the multipart framing and data field
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxCsvFileSize+1<<20)
+
 		var fileData SubmitFileData
 
 		if err := c.ShouldBind(&fileData); err != nil {
@@ -232,9 +577,9 @@ func main() {
 			return
 		}
 
-		newImg, err := submitPointsOfInterestFromCsv(file, submitCoordinatesData)
+		newImg, csvReport, err := submitPointsOfInterestFromCsv(file, submitCoordinatesData)
 		if err != nil {
-			c.JSON(http.StatusBadRequest, "Oops failed "+err.Error())
+			c.JSON(http.StatusBadRequest, gin.H{"success": false, "error": err.Error(), "csvReport": csvReport})
 			return
 		}
 
@@ -244,6 +589,11 @@ func main() {
 			return
 		}
 
+		if err := writeTmpReport(SubmissionReport{CsvReport: &csvReport}, submitCoordinatesData.Tag); err != nil {
+			c.JSON(http.StatusBadRequest, "Oops failed "+err.Error())
+			return
+		}
+
 		c.File(tmpFilePath)
 	})
 
@@ -270,6 +620,44 @@ func main() {
 		c.File(tmpFilePath)
 	})
 
+	r.POST("/submit-zone-mask", func(c *gin.Context) {
+		var fileData SubmitFileData
+
+		if err := c.ShouldBind(&fileData); err != nil {
+			c.JSON(http.StatusBadRequest, "Oops could not bind")
+			return
+		}
+
+		file, err := fileData.File.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, "Oops open file")
+			return
+		}
+
+		defer file.Close()
+
+		var submitZoneMaskData SubmitZoneMaskData
+		err = json.Unmarshal([]byte(fileData.Data), &submitZoneMaskData)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, "Oops unmarshal "+err.Error())
+			return
+		}
+
+		newImg, err := submitZoneMask(file, submitZoneMaskData)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, "Oops failed "+err.Error())
+			return
+		}
+
+		tmpFilePath, err := writeTmpFile(newImg, submitZoneMaskData.Tag)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, "Oops failed "+err.Error())
+			return
+		}
+
+		c.File(tmpFilePath)
+	})
+
 	r.POST("/confirm-map", func(c *gin.Context) {
 		form, err := c.MultipartForm()
 		if err != nil {
@@ -309,6 +697,101 @@ func main() {
 		respond(c, results, err)
 	})
 
+	r.POST("/scenarios", func(c *gin.Context) {
+		body := Scenario{}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, "Oops failed bad request input "+err.Error())
+			return
+		}
+
+		val, err := saveScenario(body)
+		respond(c, val, err)
+	})
+
+	r.GET("/scenarios", func(c *gin.Context) {
+		results, err := listScenarios()
+		respond(c, results, err)
+	})
+
+	r.GET("/scenarios/:name", func(c *gin.Context) {
+		result, err := loadScenario(c.Param("name"))
+		respond(c, result, err)
+	})
+
+	r.POST("/scenarios/:name/run", func(c *gin.Context) {
+		scenario, err := loadScenario(c.Param("name"))
+		if err != nil {
+			respond(c, scenario, err)
+			return
+		}
+
+		val, err := aggregateData(scenario.Request)
+		respond(c, val, err)
+	})
+
+	r.POST("/compare-scenarios", func(c *gin.Context) {
+		body := CompareScenariosRequest{}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, "Oops failed bad request input "+err.Error())
+			return
+		}
+
+		val, err := compareScenarios(body)
+		respond(c, val, err)
+	})
+
+	r.POST("/aggregate-group-data", func(c *gin.Context) {
+		body := GroupAggregateDataRequest{}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, "Oops failed bad request input "+err.Error())
+			return
+		}
+
+		val, err := aggregateGroupData(body)
+		respond(c, val, err)
+	})
+
+	r.POST("/derive-ahp-weights", func(c *gin.Context) {
+		body := AhpWeightsRequest{}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, "Oops failed bad request input "+err.Error())
+			return
+		}
+
+		val, err := deriveAhpWeights(body)
+		respond(c, val, err)
+	})
+
+	r.POST("/analyze-sensitivity", func(c *gin.Context) {
+		body := SensitivityAnalysisRequest{}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, "Oops failed bad request input "+err.Error())
+			return
+		}
+
+		val, err := analyzeSensitivity(body)
+		respond(c, val, err)
+	})
+
+	r.GET("/score", func(c *gin.Context) {
+		lat, latErr := strconv.ParseFloat(c.Query("lat"), 64)
+		long, longErr := strconv.ParseFloat(c.Query("long"), 64)
+		if latErr != nil || longErr != nil {
+			c.JSON(http.StatusBadRequest, "Oops lat and long must be numbers")
+			return
+		}
+
+		// the aggregation request, json encoded
+		var request AggregateDataRequest
+		if err := json.Unmarshal([]byte(c.Query("request")), &request); err != nil {
+			c.JSON(http.StatusBadRequest, "Oops unmarshal "+err.Error())
+			return
+		}
+
+		result, err := scoreLocation(LatLong{Lat: lat, Long: long}, request)
+		respond(c, result, err)
+	})
+
 	r.GET("/overlay-bounds", func(c *gin.Context) {
 		result, err := getOverlayBounds()
 		respond(c, result, err)
@@ -339,6 +822,11 @@ func respond[T any](c *gin.Context, val T, err error) {
 func writeTmpFile(img *image.RGBA, tag string) (string, error) {
 	tmpFilepath := fmt.Sprintf("./tmp-database/%s.png", tag)
 
+	// a report left by an earlier preview of the tag no longer describes it
+	if err := os.Remove(tmpReportPath(tag)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("error removing stale report: %w", err)
+	}
+
 	newFile, err := os.Create(tmpFilepath)
 	if err != nil {
 		return "", fmt.Errorf("error writing temp data: %w", err)
@@ -366,6 +854,8 @@ func confirmMap(submittedFile io.Reader, data ConfirmMapData) error {
 		return err
 	}
 
+	invalidateStoredMap(data.Tag)
+
 	return nil
 }
 
@@ -382,3 +872,32 @@ func getTags() ([]string, error) {
 
 	return tags, nil
 }
+
+func tmpReportPath(tag string) string {
+	return fmt.Sprintf("./tmp-database/%s-report.json", tag)
+}
+
+func writeTmpReport(report SubmissionReport, tag string) error {
+	reportJson, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(tmpReportPath(tag), reportJson, 0644); err != nil {
+		return fmt.Errorf("error writing temp report: %w", err)
+	}
+
+	return nil
+}
+
+func readTmpReport(tag string) (SubmissionReport, error) {
+	var report SubmissionReport
+
+	reportJson, err := os.ReadFile(tmpReportPath(tag))
+	if err != nil {
+		return report, err
+	}
+
+	err = json.Unmarshal(reportJson, &report)
+	return report, err
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"os"
+	"testing"
+)
+
+func TestTmpReport(t *testing.T) {
+	chdirTemp(t)
+	if err := os.MkdirAll("./tmp-database", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	report := SubmissionReport{
+		RegistrationReport: &RegistrationReport{Transform: "affine", RmsResidualPx: 1.5},
+		Diagnostics:        &ChoroplethDiagnostics{OverlayPixels: 10, MatchedPixels: 8},
+		// far more rows than would have fit in a header
+		CsvReport: &CsvReport{RowErrors: make([]CsvRowError, 500)},
+	}
+	if err := writeTmpReport(report, "reportA"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := readTmpReport("reportA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.RegistrationReport == nil || got.RegistrationReport.Transform != "affine" || got.RegistrationReport.RmsResidualPx != 1.5 {
+		t.Errorf("report round tripped as %+v", got)
+	}
+	if got.Diagnostics == nil || got.Diagnostics.OverlayPixels != 10 || got.Diagnostics.MatchedPixels != 8 {
+		t.Errorf("diagnostics round tripped as %+v", got.Diagnostics)
+	}
+	if got.CsvReport == nil || len(got.CsvReport.RowErrors) != 500 {
+		t.Errorf("csv report lost rows, got %+v", got.CsvReport)
+	}
+
+	// a new preview without a report of its own drops the stale one
+	if _, err := writeTmpFile(image.NewRGBA(image.Rect(0, 0, 1, 1)), "reportA"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readTmpReport("reportA"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("stale report was kept, got error %v", err)
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"image"
 	"image/color"
+	"math"
 )
 
 func valueColor(value float64) color.Color {
@@ -10,7 +11,20 @@ func valueColor(value float64) color.Color {
 	// return color.RGBA{R: 75, G: uint8(75 + ((1.0 - value) * 180)), B: 75, A: 255}
 }
 
+// noDataColor marks overlay pixels of a stored dataset that have no value
+var noDataColor = color.RGBA{R: 220, G: 0, B: 0, A: 255}
+
+// isNoDataPixel tells whether a stored dataset's overlay pixel has no value,
+// either painted as no data or left transparent (e.g. unmatched legend colors)
+func isNoDataPixel(r, g, b, a uint8) bool {
+	return a == 0 || r > 0
+}
+
 func isWithinOverlay(overlayImg image.Image, x, y int) bool {
+	if mask, isMask := overlayImg.(*OverlayMask); isMask {
+		return mask.Contains(x, y)
+	}
+
 	r, g, b, a := overlayImg.At(x, y).RGBA()
 	return r == 0 && g == 0 && b == 0 && a != 0
 }
@@ -23,8 +37,38 @@ func getOverlayLatLongGaps(width, height int, overlayLatLongBounds OverlayBounds
 }
 
 func getLatLong(x, y int, gapX, gapY float64, overlayLatLongBounds OverlayBounds) (float64, float64) {
-	lat := overlayLatLongBounds.TopLeft.Lat - float64(y)*gapY
-	long := overlayLatLongBounds.TopLeft.Long + float64(x)*gapX
+	if isEquirectangular(overlayLatLongBounds.proj) {
+		lat := overlayLatLongBounds.TopLeft.Lat - float64(y)*gapY
+		long := overlayLatLongBounds.TopLeft.Long + float64(x)*gapX
+
+		return lat, long
+	}
+
+	// the gaps are linear in lat/long, so recover the pixel's fractional position and interpolate in projected space instead
+	fracX := float64(x) * gapX / (overlayLatLongBounds.BottomRight.Long - overlayLatLongBounds.TopLeft.Long)
+	fracY := float64(y) * gapY / (overlayLatLongBounds.TopLeft.Lat - overlayLatLongBounds.BottomRight.Lat)
+
+	projX := overlayLatLongBounds.projTopLeftX + fracX*(overlayLatLongBounds.projBottomRightX-overlayLatLongBounds.projTopLeftX)
+	projY := overlayLatLongBounds.projTopLeftY + fracY*(overlayLatLongBounds.projBottomRightY-overlayLatLongBounds.projTopLeftY)
+
+	return overlayLatLongBounds.proj.Inverse(projX, projY)
+}
+
+// getPixel is the inverse of getLatLong, giving the pixel a lat/long falls within
+func getPixel(lat, long, gapX, gapY float64, overlayLatLongBounds OverlayBounds) (int, int) {
+	if isEquirectangular(overlayLatLongBounds.proj) {
+		x := (long - overlayLatLongBounds.TopLeft.Long) / gapX
+		y := (overlayLatLongBounds.TopLeft.Lat - lat) / gapY
+
+		return int(math.Floor(x)), int(math.Floor(y))
+	}
+
+	projX, projY := overlayLatLongBounds.proj.Forward(lat, long)
+	fracX := (projX - overlayLatLongBounds.projTopLeftX) / (overlayLatLongBounds.projBottomRightX - overlayLatLongBounds.projTopLeftX)
+	fracY := (projY - overlayLatLongBounds.projTopLeftY) / (overlayLatLongBounds.projBottomRightY - overlayLatLongBounds.projTopLeftY)
+
+	x := fracX * (overlayLatLongBounds.BottomRight.Long - overlayLatLongBounds.TopLeft.Long) / gapX
+	y := fracY * (overlayLatLongBounds.TopLeft.Lat - overlayLatLongBounds.BottomRight.Lat) / gapY
 
-	return lat, long
+	return int(math.Floor(x)), int(math.Floor(y))
 }
@@ -57,10 +57,19 @@ func readOverlay() ([][]bool, error) {
 		return nil, err
 	}
 
+	invalidateOverlay()
+
 	return pixels, nil
 }
 
 func getOverlayFile() (image.Image, error) {
+	overlayCache.Lock()
+	defer overlayCache.Unlock()
+
+	if overlayCache.mask != nil {
+		return overlayCache.mask, nil
+	}
+
 	overlayMapFile, err := os.Open("./assets/blackwhite.png")
 	if err != nil {
 		return nil, fmt.Errorf("failed to read overlay image: %w", err)
@@ -73,7 +82,9 @@ func getOverlayFile() (image.Image, error) {
 		return nil, fmt.Errorf("failed to decode overlay map: %w", err)
 	}
 
-	return overlayMapImg, nil
+	overlayCache.mask = newOverlayMask(overlayMapImg)
+
+	return overlayCache.mask, nil
 }
 
 func getOverlayBounds() (OverlayBounds, error) {
@@ -88,6 +99,14 @@ func getOverlayBounds() (OverlayBounds, error) {
 		return overlayData, fmt.Errorf("failed to parse overlay data json: %w", err)
 	}
 
+	overlayData.proj, err = newProjection(overlayData.Projection)
+	if err != nil {
+		return overlayData, fmt.Errorf("invalid overlay projection: %w", err)
+	}
+
+	overlayData.projTopLeftX, overlayData.projTopLeftY = overlayData.proj.Forward(overlayData.TopLeft.Lat, overlayData.TopLeft.Long)
+	overlayData.projBottomRightX, overlayData.projBottomRightY = overlayData.proj.Forward(overlayData.BottomRight.Lat, overlayData.BottomRight.Long)
+
 	return overlayData, nil
 }
 
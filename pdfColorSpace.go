@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+
+	"rsc.io/pdf"
+)
+
+// pdfColorSpace converts color operands or image samples to RGB. Separation
+// and DeviceN tints go through their tint transform into the alternate space,
+// and Indexed colors through their lookup table into the base space.
+type pdfColorSpace struct {
+	// DeviceGray, DeviceRGB, DeviceCMYK, Separation, DeviceN, Indexed or Pattern
+	family     string
+	components int
+
+	alternate     *pdfColorSpace
+	tintTransform pdfFunction
+
+	base   *pdfColorSpace
+	hival  int
+	lookup []byte
+}
+
+var pdfDeviceGray = pdfColorSpace{family: "DeviceGray", components: 1}
+
+// color spaces can refer to others by name, so bound how deep that goes
+const pdfMaxColorSpaceDepth = 8
+
+func resolvePdfColorSpace(v pdf.Value, resources pdf.Value) (pdfColorSpace, error) {
+	return resolvePdfColorSpaceDepth(v, resources, 0)
+}
+
+func resolvePdfColorSpaceDepth(v pdf.Value, resources pdf.Value, depth int) (pdfColorSpace, error) {
+	if depth > pdfMaxColorSpaceDepth {
+		return pdfColorSpace{}, fmt.Errorf("pdf color spaces nest too deeply")
+	}
+
+	if v.Kind() == pdf.Name {
+		switch v.Name() {
+		case "DeviceGray", "G", "CalGray":
+			return pdfDeviceGray, nil
+		case "DeviceRGB", "RGB", "CalRGB":
+			return pdfColorSpace{family: "DeviceRGB", components: 3}, nil
+		case "DeviceCMYK", "CMYK":
+			return pdfColorSpace{family: "DeviceCMYK", components: 4}, nil
+		case "Pattern":
+			return pdfColorSpace{family: "Pattern"}, nil
+		}
+
+		named := resources.Key("ColorSpace").Key(v.Name())
+		if named.IsNull() {
+			return pdfColorSpace{}, fmt.Errorf("unknown pdf color space %s", v.Name())
+		}
+
+		return resolvePdfColorSpaceDepth(named, resources, depth+1)
+	}
+
+	if v.Kind() != pdf.Array || v.Len() == 0 {
+		return pdfColorSpace{}, fmt.Errorf("invalid pdf color space")
+	}
+
+	switch family := v.Index(0).Name(); family {
+	case "DeviceGray", "DeviceRGB", "DeviceCMYK", "CalGray", "CalRGB", "Pattern":
+		return resolvePdfColorSpaceDepth(v.Index(0), resources, depth+1)
+	case "ICCBased":
+		profile := v.Index(1)
+		if alternate := profile.Key("Alternate"); !alternate.IsNull() {
+			return resolvePdfColorSpaceDepth(alternate, resources, depth+1)
+		}
+
+		switch profile.Key("N").Int64() {
+		case 1:
+			return pdfDeviceGray, nil
+		case 3:
+			return pdfColorSpace{family: "DeviceRGB", components: 3}, nil
+		case 4:
+			return pdfColorSpace{family: "DeviceCMYK", components: 4}, nil
+		default:
+			return pdfColorSpace{}, fmt.Errorf("unsupported pdf ICC profile with %d components", profile.Key("N").Int64())
+		}
+	case "Separation", "DeviceN":
+		if v.Len() < 4 {
+			return pdfColorSpace{}, fmt.Errorf("invalid pdf %s color space", family)
+		}
+
+		alternate, err := resolvePdfColorSpaceDepth(v.Index(2), resources, depth+1)
+		if err != nil {
+			return pdfColorSpace{}, err
+		}
+
+		tintTransform, err := compilePdfFunction(v.Index(3))
+		if err != nil {
+			return pdfColorSpace{}, fmt.Errorf("invalid pdf %s tint transform: %w", family, err)
+		}
+
+		components := 1
+		if family == "DeviceN" {
+			components = v.Index(1).Len()
+		}
+
+		return pdfColorSpace{family: family, components: components, alternate: &alternate, tintTransform: tintTransform}, nil
+	case "Indexed", "I":
+		if v.Len() < 4 {
+			return pdfColorSpace{}, fmt.Errorf("invalid pdf indexed color space")
+		}
+
+		base, err := resolvePdfColorSpaceDepth(v.Index(1), resources, depth+1)
+		if err != nil {
+			return pdfColorSpace{}, err
+		}
+		if base.components == 0 {
+			return pdfColorSpace{}, fmt.Errorf("pdf indexed color space has no usable base")
+		}
+
+		lookup := []byte(v.Index(3).RawString())
+		if v.Index(3).Kind() == pdf.Stream {
+			lookup, err = io.ReadAll(v.Index(3).Reader())
+			if err != nil {
+				return pdfColorSpace{}, fmt.Errorf("failed to read pdf indexed lookup: %w", err)
+			}
+		}
+
+		hival := int(v.Index(2).Int64())
+		if hival < 0 || hival > 255 || len(lookup) < (hival+1)*base.components {
+			return pdfColorSpace{}, fmt.Errorf("pdf indexed lookup is shorter than its %d colors", hival+1)
+		}
+
+		return pdfColorSpace{family: "Indexed", components: 1, base: &base, hival: hival, lookup: lookup}, nil
+	default:
+		return pdfColorSpace{}, fmt.Errorf("unsupported pdf color space %s", family)
+	}
+}
+
+// initialColor is the color a space starts with once selected, which is black for the device spaces
+func (cs pdfColorSpace) initialColor() []float64 {
+	switch cs.family {
+	case "DeviceCMYK":
+		return []float64{0, 0, 0, 1}
+	case "Separation", "DeviceN":
+		// full tint of every colorant
+		initial := make([]float64, cs.components)
+		for i := range initial {
+			initial[i] = 1
+		}
+		return initial
+	default:
+		return make([]float64, cs.components)
+	}
+}
+
+// toNRGBA converts color operands, returning false for pattern colors, which aren't drawn
+func (cs pdfColorSpace) toNRGBA(nums []float64) (color.NRGBA, bool, error) {
+	if cs.family == "Pattern" {
+		return color.NRGBA{}, false, nil
+	}
+
+	if len(nums) != cs.components {
+		return color.NRGBA{}, false, fmt.Errorf("pdf %s color needs %d components, got %d", cs.family, cs.components, len(nums))
+	}
+
+	toByte := func(v float64) uint8 {
+		return uint8(math.Round(math.Max(0, math.Min(1, v)) * 255))
+	}
+
+	switch cs.family {
+	case "DeviceGray":
+		return color.NRGBA{R: toByte(nums[0]), G: toByte(nums[0]), B: toByte(nums[0]), A: 255}, true, nil
+	case "DeviceRGB":
+		return color.NRGBA{R: toByte(nums[0]), G: toByte(nums[1]), B: toByte(nums[2]), A: 255}, true, nil
+	case "DeviceCMYK":
+		c, m, y, k := nums[0], nums[1], nums[2], nums[3]
+		return color.NRGBA{R: toByte((1 - c) * (1 - k)), G: toByte((1 - m) * (1 - k)), B: toByte((1 - y) * (1 - k)), A: 255}, true, nil
+	case "Indexed":
+		i := max(0, min(cs.hival, int(math.Round(nums[0]))))
+		baseNums := make([]float64, cs.base.components)
+		for ch := range baseNums {
+			baseNums[ch] = float64(cs.lookup[i*cs.base.components+ch]) / 255
+		}
+		return cs.base.toNRGBA(baseNums)
+	default: // Separation, DeviceN
+		alternateNums, err := cs.tintTransform.Eval(nums)
+		if err != nil {
+			return color.NRGBA{}, false, err
+		}
+		return cs.alternate.toNRGBA(alternateNums)
+	}
+}
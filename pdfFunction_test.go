@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+func TestPdfCalculatorFunction(t *testing.T) {
+	tests := []struct {
+		source    string
+		in        []float64
+		rangeVals []float64
+		want      []float64
+	}{
+		{"{ 2 mul }", []float64{0.25}, []float64{0, 1}, []float64{0.5}},
+		{"{ 2 mul }", []float64{0.75}, []float64{0, 1}, []float64{1}},
+		{"{ dup 0.5 gt { pop 1 } { 0.5 mul } ifelse }", []float64{0.8}, []float64{0, 1}, []float64{1}},
+		{"{ dup 0.5 gt { pop 1 } { 0.5 mul } ifelse }", []float64{0.4}, []float64{0, 1}, []float64{0.2}},
+		{"{ 1 exch sub dup 1 }", []float64{0.25}, []float64{0, 1, 0, 1, 0, 1}, []float64{0.75, 0.75, 1}},
+		{"{ 1 2 3 3 1 roll }", []float64{0}, []float64{-9, 9, -9, 9, -9, 9}, []float64{3, 1, 2}},
+		{"{ 7 2 idiv 7 2 mod }", []float64{0}, []float64{-9, 9, -9, 9}, []float64{3, 1}},
+		{"{ 2 3 exp 90 sin }", []float64{0}, []float64{-9, 9, -9, 9}, []float64{8, 1}},
+		{"{ 0.5 lt true and { 1 } if }", []float64{0.2}, []float64{0, 1}, []float64{1}},
+		{"{ 1 1 index 2 copy add add add }", []float64{0.5}, []float64{0, 9}, []float64{3}},
+	}
+
+	for _, test := range tests {
+		program, err := parsePdfCalculator(test.source)
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+
+		function := pdfCalculatorFunction{domain: []float64{0, 1}, rangeVals: test.rangeVals, program: program}
+		got, err := function.Eval(test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.source, err)
+			continue
+		}
+
+		for i := range test.want {
+			if diff := got[i] - test.want[i]; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("%s(%v) = %v, want %v", test.source, test.in, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestPdfCalculatorFunctionErrors(t *testing.T) {
+	tests := []string{"2 mul", "{ 2 mul", "{ pop pop }", "{ 1 0 div }", "{ 1 foo }", "{ 1 if }"}
+
+	for _, source := range tests {
+		program, err := parsePdfCalculator(source)
+		if err != nil {
+			continue
+		}
+
+		function := pdfCalculatorFunction{domain: []float64{0, 1}, rangeVals: []float64{0, 1}, program: program}
+		if _, err := function.Eval([]float64{0.5}); err == nil {
+			t.Errorf("%s: expected an error", source)
+		}
+	}
+}
@@ -0,0 +1,799 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+	"slices"
+
+	"rsc.io/pdf"
+)
+
+const (
+	defaultPdfDpi = 150.0
+	// curves are flattened to this many line segments
+	pdfCurveSegments = 16
+	// paths are sampled this many times per pixel along each axis
+	pdfCoverageSamples = 4
+	// form XObjects can draw others, so bound how deep that goes and how many are drawn in all
+	pdfMaxFormDepth = 16
+	pdfMaxFormDraws = 100_000
+)
+
+// pdfMatrix is a PDF transformation matrix [a b c d e f], mapping (x, y) to (a*x + c*y + e, b*x + d*y + f)
+type pdfMatrix [6]float64
+
+// then returns the transform applying m followed by n
+func (m pdfMatrix) then(n pdfMatrix) pdfMatrix {
+	return pdfMatrix{
+		m[0]*n[0] + m[1]*n[2],
+		m[0]*n[1] + m[1]*n[3],
+		m[2]*n[0] + m[3]*n[2],
+		m[2]*n[1] + m[3]*n[3],
+		m[4]*n[0] + m[5]*n[2] + n[4],
+		m[4]*n[1] + m[5]*n[3] + n[5],
+	}
+}
+
+func (m pdfMatrix) apply(x, y float64) (float64, float64) {
+	return m[0]*x + m[2]*y + m[4], m[1]*x + m[3]*y + m[5]
+}
+
+func (m pdfMatrix) inverse() (pdfMatrix, bool) {
+	det := m[0]*m[3] - m[1]*m[2]
+	if det == 0 {
+		return pdfMatrix{}, false
+	}
+
+	return pdfMatrix{
+		m[3] / det,
+		-m[1] / det,
+		-m[2] / det,
+		m[0] / det,
+		(m[2]*m[5] - m[3]*m[4]) / det,
+		(m[1]*m[4] - m[0]*m[5]) / det,
+	}, true
+}
+
+type pdfGraphicsState struct {
+	ctm         pdfMatrix
+	fillColor   color.NRGBA
+	strokeColor color.NRGBA
+	fillSpace   pdfColorSpace
+	strokeSpace pdfColorSpace
+	lineWidth   float64
+	clip        image.Rectangle
+	// coverage of the clipping path within clip, or nil if the clip is just the rectangle
+	clipMask *image.Alpha
+}
+
+// pdfSubpath is a run of points in device space, curves already flattened
+type pdfSubpath struct {
+	points []vectorPoint
+	closed bool
+}
+
+type vectorPoint struct {
+	x, y float64
+}
+
+// pdfRenderer paints the vector content of a PDF page, which is what
+// statistical maps consist of: paths filled with the nonzero or even-odd rule
+// and stroked, in device, Separation, DeviceN or Indexed colors, clipping
+// paths, form XObjects, and 8 bit images without compression or with Flate.
+// Text, shadings, patterns and soft masks are not drawn.
+type pdfRenderer struct {
+	dst                *image.RGBA
+	state              pdfGraphicsState
+	stateStack         []pdfGraphicsState
+	path               []pdfSubpath
+	pendingClip        bool
+	pendingClipEvenOdd bool
+	// forms currently being drawn, keyed by their header and file offset, which is
+	// the only identity the parser exposes, so a form drawing itself is caught early
+	drawingForms map[string]bool
+	numFormDraws int
+	// the first color space or function that couldn't be interpreted, which fails the render
+	err error
+}
+
+// renderPdfPage rasterizes the given 1-based page of a PDF at the given DPI
+func renderPdfPage(pdfBytes []byte, pageNum int, dpi float64) (img *image.RGBA, err error) {
+	// the PDF parser panics on malformed or unsupported input
+	defer func() {
+		if r := recover(); r != nil {
+			img, err = nil, fmt.Errorf("unsupported or malformed PDF: %v", r)
+		}
+	}()
+
+	if dpi == 0 {
+		dpi = defaultPdfDpi
+	}
+	if pageNum == 0 {
+		pageNum = 1
+	}
+
+	reader, err := pdf.NewReader(bytes.NewReader(pdfBytes), int64(len(pdfBytes)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PDF: %w", err)
+	}
+
+	if pageNum < 1 || pageNum > reader.NumPage() {
+		return nil, fmt.Errorf("page %d out of range, PDF has %d pages", pageNum, reader.NumPage())
+	}
+
+	page := reader.Page(pageNum)
+
+	box := findInheritedPageKey(page, "CropBox")
+	if box.IsNull() {
+		box = findInheritedPageKey(page, "MediaBox")
+	}
+	if box.Len() != 4 {
+		return nil, fmt.Errorf("page %d has no media box", pageNum)
+	}
+
+	x0, y0 := math.Min(box.Index(0).Float64(), box.Index(2).Float64()), math.Min(box.Index(1).Float64(), box.Index(3).Float64())
+	x1, y1 := math.Max(box.Index(0).Float64(), box.Index(2).Float64()), math.Max(box.Index(1).Float64(), box.Index(3).Float64())
+
+	// pages are displayed turned clockwise by their rotation, so rasterize them the way they're seen
+	rotation := (findInheritedPageKey(page, "Rotate").Int64()%360 + 360) % 360
+	if rotation%90 != 0 {
+		return nil, fmt.Errorf("page %d rotation %d isn't a multiple of 90", pageNum, rotation)
+	}
+
+	scale := dpi / 72
+	width, height := int(math.Ceil((x1-x0)*scale)), int(math.Ceil((y1-y0)*scale))
+	if rotation == 90 || rotation == 270 {
+		width, height = height, width
+	}
+	if width <= 0 || height <= 0 || width > maxRasterDimension || height > maxRasterDimension {
+		return nil, fmt.Errorf("pdf raster size %dx%d must be positive and at most %d pixels per side, try a lower DPI", width, height, maxRasterDimension)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.White, image.Point{}, draw.Src)
+
+	// pdf space has y pointing up from the bottom left of the page
+	ctm := pdfMatrix{scale, 0, 0, -scale, -x0 * scale, y1 * scale}
+	switch rotation {
+	case 90:
+		ctm = pdfMatrix{0, scale, scale, 0, -y0 * scale, -x0 * scale}
+	case 180:
+		ctm = pdfMatrix{-scale, 0, 0, scale, x1 * scale, -y0 * scale}
+	case 270:
+		ctm = pdfMatrix{0, -scale, -scale, 0, y1 * scale, x1 * scale}
+	}
+
+	renderer := pdfRenderer{
+		dst:          dst,
+		drawingForms: map[string]bool{},
+		state: pdfGraphicsState{
+			ctm:         ctm,
+			fillColor:   color.NRGBA{A: 255},
+			strokeColor: color.NRGBA{A: 255},
+			fillSpace:   pdfDeviceGray,
+			strokeSpace: pdfDeviceGray,
+			lineWidth:   1,
+			clip:        dst.Bounds(),
+		},
+	}
+
+	contents := page.V.Key("Contents")
+	if contents.Kind() == pdf.Array {
+		for i := range contents.Len() {
+			renderer.interpret(contents.Index(i), page.Resources())
+		}
+	} else {
+		renderer.interpret(contents, page.Resources())
+	}
+
+	if renderer.err != nil {
+		return nil, fmt.Errorf("failed to render PDF page %d: %w", pageNum, renderer.err)
+	}
+
+	return dst, nil
+}
+
+func findInheritedPageKey(page pdf.Page, key string) pdf.Value {
+	for v := page.V; !v.IsNull(); v = v.Key("Parent") {
+		if r := v.Key(key); !r.IsNull() {
+			return r
+		}
+	}
+
+	return pdf.Value{}
+}
+
+func (r *pdfRenderer) interpret(stream pdf.Value, resources pdf.Value) {
+	pdf.Interpret(stream, func(stk *pdf.Stack, op string) {
+		args := make([]pdf.Value, stk.Len())
+		for i := len(args) - 1; i >= 0; i-- {
+			args[i] = stk.Pop()
+		}
+
+		r.execute(op, args, resources)
+	})
+}
+
+func (r *pdfRenderer) execute(op string, args []pdf.Value, resources pdf.Value) {
+	if r.err != nil {
+		return
+	}
+
+	nums := make([]float64, len(args))
+	for i, arg := range args {
+		nums[i] = arg.Float64()
+	}
+
+	switch op {
+	case "q":
+		r.stateStack = append(r.stateStack, r.state)
+	case "Q":
+		if len(r.stateStack) > 0 {
+			r.state = r.stateStack[len(r.stateStack)-1]
+			r.stateStack = r.stateStack[:len(r.stateStack)-1]
+		}
+	case "cm":
+		if len(nums) == 6 {
+			r.state.ctm = pdfMatrix(nums).then(r.state.ctm)
+		}
+	case "w":
+		if len(nums) == 1 {
+			r.state.lineWidth = nums[0]
+		}
+	case "gs":
+		if len(args) == 1 {
+			extGState := resources.Key("ExtGState").Key(args[0].Name())
+			if alpha := extGState.Key("ca"); !alpha.IsNull() {
+				r.state.fillColor.A = uint8(math.Round(alpha.Float64() * 255))
+			}
+			if alpha := extGState.Key("CA"); !alpha.IsNull() {
+				r.state.strokeColor.A = uint8(math.Round(alpha.Float64() * 255))
+			}
+		}
+
+	case "g", "rg", "k":
+		r.state.fillSpace = pdfDeviceColorSpace(len(nums))
+		r.setColor(&r.state.fillColor, r.state.fillSpace, nums, args)
+	case "G", "RG", "K":
+		r.state.strokeSpace = pdfDeviceColorSpace(len(nums))
+		r.setColor(&r.state.strokeColor, r.state.strokeSpace, nums, args)
+	case "sc", "scn":
+		r.setColor(&r.state.fillColor, r.state.fillSpace, nums, args)
+	case "SC", "SCN":
+		r.setColor(&r.state.strokeColor, r.state.strokeSpace, nums, args)
+	case "cs", "CS":
+		if len(args) != 1 {
+			return
+		}
+
+		space, err := resolvePdfColorSpace(args[0], resources)
+		if err != nil {
+			r.err = err
+			return
+		}
+
+		if op == "cs" {
+			r.state.fillSpace = space
+			r.setColor(&r.state.fillColor, space, space.initialColor(), nil)
+		} else {
+			r.state.strokeSpace = space
+			r.setColor(&r.state.strokeColor, space, space.initialColor(), nil)
+		}
+
+	case "m":
+		if len(nums) == 2 {
+			r.path = append(r.path, pdfSubpath{points: []vectorPoint{r.devicePoint(nums[0], nums[1])}})
+		}
+	case "l":
+		if len(nums) == 2 && len(r.path) > 0 {
+			r.lineTo(r.devicePoint(nums[0], nums[1]))
+		}
+	case "c":
+		if len(nums) == 6 {
+			r.curveTo(r.devicePoint(nums[0], nums[1]), r.devicePoint(nums[2], nums[3]), r.devicePoint(nums[4], nums[5]))
+		}
+	case "v":
+		if len(nums) == 4 {
+			r.curveTo(r.currentPoint(), r.devicePoint(nums[0], nums[1]), r.devicePoint(nums[2], nums[3]))
+		}
+	case "y":
+		if len(nums) == 4 {
+			end := r.devicePoint(nums[2], nums[3])
+			r.curveTo(r.devicePoint(nums[0], nums[1]), end, end)
+		}
+	case "h":
+		r.closePath()
+	case "re":
+		if len(nums) == 4 {
+			x, y, w, h := nums[0], nums[1], nums[2], nums[3]
+			r.path = append(r.path, pdfSubpath{
+				points: []vectorPoint{r.devicePoint(x, y), r.devicePoint(x+w, y), r.devicePoint(x+w, y+h), r.devicePoint(x, y+h)},
+				closed: true,
+			})
+		}
+
+	case "W", "W*":
+		// applied once the path is painted or discarded, as in the PDF spec
+		r.pendingClip = true
+		r.pendingClipEvenOdd = op == "W*"
+	case "n":
+		r.endPath()
+	case "f", "F", "f*":
+		r.fillPath(op == "f*")
+		r.endPath()
+	case "S":
+		r.strokePath()
+		r.endPath()
+	case "s":
+		r.closePath()
+		r.strokePath()
+		r.endPath()
+	case "B", "B*":
+		r.fillPath(op == "B*")
+		r.strokePath()
+		r.endPath()
+	case "b", "b*":
+		r.closePath()
+		r.fillPath(op == "b*")
+		r.strokePath()
+		r.endPath()
+
+	case "Do":
+		if len(args) == 1 {
+			r.drawXObject(resources.Key("XObject").Key(args[0].Name()), resources)
+		}
+	}
+}
+
+// pdfDeviceColorSpace is the space set by the g, rg and k operators, told apart by their number of operands
+func pdfDeviceColorSpace(components int) pdfColorSpace {
+	switch components {
+	case 3:
+		return pdfColorSpace{family: "DeviceRGB", components: 3}
+	case 4:
+		return pdfColorSpace{family: "DeviceCMYK", components: 4}
+	default:
+		return pdfDeviceGray
+	}
+}
+
+// setColor converts color operands in the given space, keeping the current
+// alpha. Pattern colors have a name operand and are ignored.
+func (r *pdfRenderer) setColor(c *color.NRGBA, space pdfColorSpace, nums []float64, args []pdf.Value) {
+	for _, arg := range args {
+		if arg.Kind() == pdf.Name {
+			return
+		}
+	}
+
+	newColor, ok, err := space.toNRGBA(nums)
+	if err != nil {
+		r.err = err
+		return
+	}
+
+	if ok {
+		newColor.A = c.A
+		*c = newColor
+	}
+}
+
+func (r *pdfRenderer) devicePoint(x, y float64) vectorPoint {
+	dx, dy := r.state.ctm.apply(x, y)
+	return vectorPoint{dx, dy}
+}
+
+func (r *pdfRenderer) currentPoint() vectorPoint {
+	if len(r.path) == 0 {
+		return vectorPoint{}
+	}
+
+	points := r.path[len(r.path)-1].points
+	return points[len(points)-1]
+}
+
+func (r *pdfRenderer) lineTo(p vectorPoint) {
+	subpath := &r.path[len(r.path)-1]
+	subpath.points = append(subpath.points, p)
+}
+
+func (r *pdfRenderer) curveTo(c1, c2, end vectorPoint) {
+	if len(r.path) == 0 {
+		return
+	}
+
+	start := r.currentPoint()
+	for i := 1; i <= pdfCurveSegments; i++ {
+		t := float64(i) / pdfCurveSegments
+		mt := 1 - t
+		r.lineTo(vectorPoint{
+			mt*mt*mt*start.x + 3*mt*mt*t*c1.x + 3*mt*t*t*c2.x + t*t*t*end.x,
+			mt*mt*mt*start.y + 3*mt*mt*t*c1.y + 3*mt*t*t*c2.y + t*t*t*end.y,
+		})
+	}
+}
+
+func (r *pdfRenderer) closePath() {
+	if len(r.path) > 0 {
+		r.path[len(r.path)-1].closed = true
+	}
+}
+
+func (r *pdfRenderer) endPath() {
+	if r.pendingClip {
+		r.intersectClip(r.path, r.pendingClipEvenOdd)
+		r.pendingClip = false
+	}
+
+	r.path = nil
+}
+
+func pathBounds(path []pdfSubpath) image.Rectangle {
+	minX, minY := math.MaxFloat64, math.MaxFloat64
+	maxX, maxY := -math.MaxFloat64, -math.MaxFloat64
+	for _, subpath := range path {
+		for _, p := range subpath.points {
+			minX, minY = math.Min(minX, p.x), math.Min(minY, p.y)
+			maxX, maxY = math.Max(maxX, p.x), math.Max(maxY, p.y)
+		}
+	}
+
+	if minX > maxX {
+		return image.Rectangle{}
+	}
+
+	return image.Rect(int(math.Floor(minX)), int(math.Floor(minY)), int(math.Ceil(maxX)), int(math.Ceil(maxY)))
+}
+
+// intersectClip narrows the clip to the inside of the path
+func (r *pdfRenderer) intersectClip(path []pdfSubpath, evenOdd bool) {
+	bounds := pathBounds(path).Intersect(r.state.clip)
+	coverage := pathCoverage(path, bounds, evenOdd)
+
+	isFullyCovered := true
+	for i := range coverage.Pix {
+		isFullyCovered = isFullyCovered && coverage.Pix[i] == 255
+	}
+
+	// rectangles on pixel boundaries, the most common clip, don't need a mask
+	if r.state.clipMask == nil && isFullyCovered {
+		r.state.clip = bounds
+		return
+	}
+
+	r.applyClipMask(coverage)
+	r.state.clip = bounds
+	r.state.clipMask = coverage
+}
+
+// applyClipMask scales the coverage by how much of each pixel the clipping path covers
+func (r *pdfRenderer) applyClipMask(coverage *image.Alpha) {
+	if r.state.clipMask == nil {
+		return
+	}
+
+	bounds := coverage.Rect
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			i := coverage.PixOffset(x, y)
+			coverage.Pix[i] = uint8(uint16(coverage.Pix[i]) * uint16(r.state.clipMask.AlphaAt(x, y).A) / 255)
+		}
+	}
+}
+
+func (r *pdfRenderer) fillPath(evenOdd bool) {
+	r.rasterize(r.path, r.state.fillColor, evenOdd)
+}
+
+// strokePath draws each segment as a quad of the line width, without joins or caps,
+// which is enough for the borders and outlines drawn over maps
+func (r *pdfRenderer) strokePath() {
+	ctm := r.state.ctm
+	width := math.Max(1, r.state.lineWidth*math.Sqrt(math.Abs(ctm[0]*ctm[3]-ctm[1]*ctm[2])))
+
+	quads := []pdfSubpath{}
+	for _, subpath := range r.path {
+		points := subpath.points
+		if subpath.closed && len(points) > 1 {
+			points = append(points[:len(points):len(points)], points[0])
+		}
+
+		for i := 1; i < len(points); i++ {
+			a, b := points[i-1], points[i]
+			length := math.Hypot(b.x-a.x, b.y-a.y)
+			if length == 0 {
+				continue
+			}
+
+			nx, ny := -(b.y-a.y)/length*width/2, (b.x-a.x)/length*width/2
+			quads = append(quads, pdfSubpath{
+				points: []vectorPoint{{a.x + nx, a.y + ny}, {b.x + nx, b.y + ny}, {b.x - nx, b.y - ny}, {a.x - nx, a.y - ny}},
+				closed: true,
+			})
+		}
+	}
+
+	// every quad winds the same way, so the nonzero rule fills their union
+	r.rasterize(quads, r.state.strokeColor, false)
+}
+
+// rasterize fills the path over just its bounds, so each path costs time
+// proportional to its size rather than to the whole page
+func (r *pdfRenderer) rasterize(path []pdfSubpath, c color.NRGBA, evenOdd bool) {
+	if c.A == 0 {
+		return
+	}
+
+	bounds := pathBounds(path).Intersect(r.state.clip).Intersect(r.dst.Bounds())
+	if bounds.Empty() {
+		return
+	}
+
+	coverage := pathCoverage(path, bounds, evenOdd)
+	r.applyClipMask(coverage)
+
+	draw.DrawMask(r.dst, bounds, image.NewUniform(c), image.Point{}, coverage, bounds.Min, draw.Over)
+}
+
+type pdfEdge struct {
+	x0, y0, x1, y1 float64
+	// +1 for edges running down the page, -1 for edges running up it
+	winding int
+}
+
+type pdfCrossing struct {
+	x       float64
+	winding int
+}
+
+// pathCoverage gives the fraction of each pixel within bounds that is inside
+// the path, under the nonzero or even-odd rule, by counting the edges crossed
+// left of each of a grid of sample points. Every subpath is implicitly closed.
+func pathCoverage(path []pdfSubpath, bounds image.Rectangle, evenOdd bool) *image.Alpha {
+	coverage := image.NewAlpha(bounds)
+	if bounds.Empty() {
+		return coverage
+	}
+
+	edges := []pdfEdge{}
+	for _, subpath := range path {
+		points := subpath.points
+		for i := range points {
+			a, b := points[i], points[(i+1)%len(points)]
+			switch {
+			case a.y < b.y:
+				edges = append(edges, pdfEdge{a.x, a.y, b.x, b.y, 1})
+			case a.y > b.y:
+				edges = append(edges, pdfEdge{b.x, b.y, a.x, a.y, -1})
+			}
+		}
+	}
+
+	slices.SortFunc(edges, func(a, b pdfEdge) int {
+		return cmp.Compare(a.y0, b.y0)
+	})
+
+	width := bounds.Dx()
+	sampleCounts := make([]int, width)
+	active := []pdfEdge{}
+	crossings := []pdfCrossing{}
+	nextEdge := 0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		clear(sampleCounts)
+
+		for s := range pdfCoverageSamples {
+			sampleY := float64(y) + (float64(s)+0.5)/pdfCoverageSamples
+
+			for nextEdge < len(edges) && edges[nextEdge].y0 <= sampleY {
+				active = append(active, edges[nextEdge])
+				nextEdge++
+			}
+
+			// edges span [y0, y1), so ones ending at or above the sample row are done with
+			crossings = crossings[:0]
+			stillActive := active[:0]
+			for _, edge := range active {
+				if edge.y1 <= sampleY {
+					continue
+				}
+
+				stillActive = append(stillActive, edge)
+				crossings = append(crossings, pdfCrossing{
+					x:       edge.x0 + (sampleY-edge.y0)*(edge.x1-edge.x0)/(edge.y1-edge.y0),
+					winding: edge.winding,
+				})
+			}
+			active = stillActive
+
+			slices.SortFunc(crossings, func(a, b pdfCrossing) int {
+				return cmp.Compare(a.x, b.x)
+			})
+
+			winding := 0
+			for i := 0; i+1 < len(crossings); i++ {
+				winding += crossings[i].winding
+
+				isInside := winding != 0
+				if evenOdd {
+					isInside = winding%2 != 0
+				}
+				if !isInside {
+					continue
+				}
+
+				// the sample columns whose centers lie between this crossing and the next
+				start := int(math.Ceil((crossings[i].x-float64(bounds.Min.X))*pdfCoverageSamples - 0.5))
+				end := int(math.Ceil((crossings[i+1].x-float64(bounds.Min.X))*pdfCoverageSamples - 0.5))
+				for j := max(start, 0); j < min(end, width*pdfCoverageSamples); j++ {
+					sampleCounts[j/pdfCoverageSamples]++
+				}
+			}
+		}
+
+		row := coverage.Pix[(y-bounds.Min.Y)*coverage.Stride:]
+		for x, count := range sampleCounts {
+			row[x] = uint8(count * 255 / (pdfCoverageSamples * pdfCoverageSamples))
+		}
+	}
+
+	return coverage
+}
+
+func (r *pdfRenderer) drawXObject(xObject pdf.Value, resources pdf.Value) {
+	switch xObject.Key("Subtype").Name() {
+	case "Form":
+		formKey := xObject.String()
+		switch {
+		case r.drawingForms[formKey]:
+			r.err = fmt.Errorf("pdf form XObject draws itself")
+			return
+		case len(r.drawingForms) >= pdfMaxFormDepth:
+			r.err = fmt.Errorf("pdf form XObjects nest too deeply")
+			return
+		case r.numFormDraws >= pdfMaxFormDraws:
+			r.err = fmt.Errorf("pdf page draws more than %d form XObjects", pdfMaxFormDraws)
+			return
+		}
+		r.numFormDraws++
+		r.drawingForms[formKey] = true
+		defer delete(r.drawingForms, formKey)
+
+		formResources := xObject.Key("Resources")
+		if formResources.IsNull() {
+			formResources = resources
+		}
+
+		saved, savedPath := r.state, r.path
+		if matrix := xObject.Key("Matrix"); matrix.Len() == 6 {
+			var m pdfMatrix
+			for i := range 6 {
+				m[i] = matrix.Index(i).Float64()
+			}
+			r.state.ctm = m.then(r.state.ctm)
+		}
+
+		r.path = nil
+		r.interpret(xObject, formResources)
+		r.state, r.path = saved, savedPath
+	case "Image":
+		r.drawImage(xObject, resources)
+	}
+}
+
+// drawImage paints an image XObject, which fills the unit square of user space
+func (r *pdfRenderer) drawImage(xObject pdf.Value, resources pdf.Value) {
+	filter := xObject.Key("Filter")
+	if filter.Kind() == pdf.Array && filter.Len() == 1 {
+		filter = filter.Index(0)
+	}
+	if !filter.IsNull() && filter.Name() != "FlateDecode" {
+		return
+	}
+
+	if xObject.Key("BitsPerComponent").Int64() != 8 || xObject.Key("ImageMask").Bool() {
+		return
+	}
+
+	colorSpace, err := resolvePdfColorSpace(xObject.Key("ColorSpace"), resources)
+	if err != nil {
+		r.err = err
+		return
+	}
+
+	components := colorSpace.components
+	if components == 0 {
+		return
+	}
+
+	// converting through a tint transform is slow, so single component samples are converted once per value
+	var sampleColors []color.NRGBA
+	if components == 1 {
+		sampleColors = make([]color.NRGBA, 256)
+		for sample := range sampleColors {
+			num := float64(sample) / 255
+			if colorSpace.family == "Indexed" {
+				num = float64(sample)
+			}
+
+			sampleColors[sample], _, err = colorSpace.toNRGBA([]float64{num})
+			if err != nil {
+				r.err = err
+				return
+			}
+		}
+	}
+
+	imgWidth, imgHeight := int(xObject.Key("Width").Int64()), int(xObject.Key("Height").Int64())
+	if imgWidth <= 0 || imgHeight <= 0 {
+		return
+	}
+
+	data, err := io.ReadAll(xObject.Reader())
+	if err != nil || len(data) < imgWidth*imgHeight*components {
+		return
+	}
+
+	inverse, ok := r.state.ctm.inverse()
+	if !ok {
+		return
+	}
+
+	corners := []pdfSubpath{{points: []vectorPoint{r.devicePoint(0, 0), r.devicePoint(1, 0), r.devicePoint(1, 1), r.devicePoint(0, 1)}}}
+	bounds := pathBounds(corners).Intersect(r.state.clip).Intersect(r.dst.Bounds())
+
+	nums := make([]float64, components)
+	for dy := bounds.Min.Y; dy < bounds.Max.Y; dy++ {
+		for dx := bounds.Min.X; dx < bounds.Max.X; dx++ {
+			u, v := inverse.apply(float64(dx)+0.5, float64(dy)+0.5)
+			if u < 0 || u >= 1 || v < 0 || v >= 1 {
+				continue
+			}
+
+			// image rows run from the top of the unit square down
+			ix, iy := int(u*float64(imgWidth)), int((1-v)*float64(imgHeight))
+			iy = min(iy, imgHeight-1)
+
+			coverage := uint8(255)
+			if r.state.clipMask != nil {
+				coverage = r.state.clipMask.AlphaAt(dx, dy).A
+			}
+
+			off := (iy*imgWidth + ix) * components
+			if sampleColors != nil {
+				blendPixel(r.dst, dx, dy, sampleColors[data[off]], coverage)
+				continue
+			}
+
+			for ch := range components {
+				nums[ch] = float64(data[off+ch]) / 255
+			}
+
+			c, _, err := colorSpace.toNRGBA(nums)
+			if err != nil {
+				r.err = err
+				return
+			}
+			blendPixel(r.dst, dx, dy, c, coverage)
+		}
+	}
+}
+
+// blendPixel paints c over the pixel, scaled by the fraction of it covered
+func blendPixel(dst *image.RGBA, x, y int, c color.NRGBA, coverage uint8) {
+	alpha := float64(c.A) / 255 * float64(coverage) / 255
+	if alpha == 0 {
+		return
+	}
+
+	i := dst.PixOffset(x, y)
+	for ch, val := range []uint8{c.R, c.G, c.B} {
+		dst.Pix[i+ch] = uint8(math.Round(float64(val)*alpha + float64(dst.Pix[i+ch])*(1-alpha)))
+	}
+	dst.Pix[i+3] = uint8(math.Round(255*alpha + float64(dst.Pix[i+3])*(1-alpha)))
+}
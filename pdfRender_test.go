@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// buildTestPdf writes a single page PDF of the given size in points, with the
+// extra objects numbered from 5 so resources can refer to them
+func buildTestPdf(width, height int, resources, content string, extraObjects ...string) []byte {
+	return buildTestPdfWithPageKeys(width, height, "", resources, content, extraObjects...)
+}
+
+// buildTestPdfWithPageKeys is buildTestPdf with extra entries in the page dictionary, like its rotation
+func buildTestPdfWithPageKeys(width, height int, pageKeys, resources, content string, extraObjects ...string) []byte {
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] %s /Resources %s /Contents 4 0 R >>", width, height, pageKeys, resources),
+		fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+	}
+	objects = append(objects, extraObjects...)
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xrefOffset := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xrefOffset)
+
+	return b.Bytes()
+}
+
+func testPdfStream(dict, data string) string {
+	return fmt.Sprintf("<< %s /Length %d >>\nstream\n%s\nendstream", dict, len(data), data)
+}
+
+func TestRenderPdfPage(t *testing.T) {
+	white := color.RGBA{255, 255, 255, 255}
+	black := color.RGBA{0, 0, 0, 255}
+	red := color.RGBA{255, 0, 0, 255}
+	green := color.RGBA{0, 255, 0, 255}
+	blue := color.RGBA{0, 0, 255, 255}
+
+	// a 40pt square with a 20pt hole, both wound the same way
+	squareWithHole := "0 0 40 40 re 10 10 20 20 re"
+	cmykRedSeparation := "[/Separation /Spot /DeviceCMYK << /FunctionType 2 /Domain [0 1] /C0 [0 0 0 0] /C1 [0 1 1 0] /N 1 >>]"
+
+	type pixel struct {
+		x, y int
+		want color.RGBA
+	}
+
+	tests := []struct {
+		name         string
+		resources    string
+		content      string
+		extraObjects []string
+		pixels       []pixel
+	}{
+		{
+			"nonzero fill covers the hole",
+			"<< >>", squareWithHole + " f", nil,
+			[]pixel{{5, 5, black}, {20, 20, black}},
+		},
+		{
+			"even-odd fill leaves the hole",
+			"<< >>", squareWithHole + " f*", nil,
+			[]pixel{{5, 5, black}, {20, 20, white}},
+		},
+		{
+			"even-odd fill and stroke leaves the hole",
+			"<< >>", "0 0 1 rg " + squareWithHole + " B*", nil,
+			[]pixel{{5, 5, blue}, {20, 20, white}},
+		},
+		{
+			"separation full tint goes through the tint transform",
+			"<< /ColorSpace << /CS0 " + cmykRedSeparation + " >> >>", "/CS0 cs 1 scn 0 0 40 40 re f", nil,
+			[]pixel{{20, 20, red}},
+		},
+		{
+			"separation partial tint",
+			"<< /ColorSpace << /CS0 " + cmykRedSeparation + " >> >>", "/CS0 cs 0.25 scn 0 0 40 40 re f", nil,
+			[]pixel{{20, 20, color.RGBA{255, 191, 191, 255}}},
+		},
+		{
+			"selecting a separation starts at full tint",
+			"<< /ColorSpace << /CS0 " + cmykRedSeparation + " >> >>", "/CS0 cs 0 0 40 40 re f", nil,
+			[]pixel{{20, 20, red}},
+		},
+		{
+			"separation with a calculator tint transform",
+			"<< /ColorSpace << /CS0 [/Separation /Spot /DeviceRGB 5 0 R] >> >>", "/CS0 cs 1 scn 0 0 40 40 re f",
+			[]string{testPdfStream("/FunctionType 4 /Domain [0 1] /Range [0 1 0 1 0 1]", "{ 1 exch sub dup 1 }")},
+			[]pixel{{20, 20, blue}},
+		},
+		{
+			"separation with a sampled tint transform",
+			"<< /ColorSpace << /CS0 [/Separation /Spot /DeviceRGB 5 0 R] >> >>", "/CS0 cs 0.5 scn 0 0 40 40 re f",
+			[]string{testPdfStream("/FunctionType 0 /Domain [0 1] /Range [0 1 0 1 0 1] /Size [2] /BitsPerSample 8", "\xff\xff\xff\x00\x00\xff")},
+			[]pixel{{20, 20, color.RGBA{128, 128, 255, 255}}},
+		},
+		{
+			"indexed colors look up the base space",
+			"<< /ColorSpace << /CS1 [/Indexed /DeviceRGB 1 <0000FF00FF00>] >> >>", "/CS1 cs 1 sc 0 0 40 40 re f", nil,
+			[]pixel{{20, 20, green}},
+		},
+		{
+			"triangular clip",
+			"<< >>", "0 0 m 40 0 l 0 40 l h W n 1 0 0 rg 0 0 40 40 re f", nil,
+			// pdf space points up, so the bottom left triangle is painted
+			[]pixel{{5, 35, red}, {35, 5, white}},
+		},
+		{
+			"even-odd clip excludes the hole",
+			"<< >>", squareWithHole + " W* n 0 0 1 rg 0 0 40 40 re f", nil,
+			[]pixel{{5, 5, blue}, {20, 20, white}},
+		},
+		{
+			"clips restore with the graphics state",
+			"<< >>", "q 0 0 10 10 re W n Q 0 1 0 rg 0 0 40 40 re f", nil,
+			[]pixel{{20, 20, green}},
+		},
+	}
+
+	for _, test := range tests {
+		pdfBytes := buildTestPdf(40, 40, test.resources, test.content, test.extraObjects...)
+
+		img, err := renderPdfPage(pdfBytes, 1, 72)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		for _, p := range test.pixels {
+			got := img.RGBAAt(p.x, p.y)
+			if colorDistance(got, p.want) > 2 {
+				t.Errorf("%s: pixel %d, %d = %v, want %v", test.name, p.x, p.y, got, p.want)
+			}
+		}
+	}
+}
+
+func colorDistance(a, b color.RGBA) int {
+	distance := 0
+	for _, d := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B), int(a.A) - int(b.A)} {
+		distance = max(distance, d, -d)
+	}
+
+	return distance
+}
+
+func TestRenderPdfPageRejectsUnsupportedColorSpaces(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources string
+	}{
+		{"unknown function type", "<< /ColorSpace << /CS0 [/Separation /Spot /DeviceRGB << /FunctionType 1 /Domain [0 1] >>] >> >>"},
+		{"lab", "<< /ColorSpace << /CS0 [/Lab << /WhitePoint [0.95 1 1.09] >>] >> >>"},
+		{"missing", "<< >>"},
+	}
+
+	for _, test := range tests {
+		pdfBytes := buildTestPdf(10, 10, test.resources, "/CS0 cs 0 0 10 10 re f")
+
+		if _, err := renderPdfPage(pdfBytes, 1, 72); err == nil || !strings.Contains(err.Error(), "render") {
+			t.Errorf("%s: expected a render error, got %v", test.name, err)
+		}
+	}
+}
+
+func TestRenderPdfPageRejectsRecursion(t *testing.T) {
+	separation := "<< /ColorSpace << /CS0 [/Separation /Spot /DeviceRGB 5 0 R] >> >>"
+	form := func(draws string) string {
+		return testPdfStream("/Type /XObject /Subtype /Form /BBox [0 0 10 10] /Resources << /XObject << /X0 "+draws+" >> >>", "/X0 Do")
+	}
+	// each level references the next ten times, so there are 10^4 functions to compile without any nesting deeper than 4
+	wideFunction := func(next string) string {
+		return "[" + strings.Repeat(next+" ", 10) + "]"
+	}
+	exponential := "<< /FunctionType 2 /Domain [0 1] /C0 [0] /C1 [1] /N 1 >>"
+
+	tests := []struct {
+		name         string
+		resources    string
+		content      string
+		extraObjects []string
+		wantErr      string
+	}{
+		{"form drawing itself", "<< /XObject << /X0 5 0 R >> >>", "/X0 Do", []string{form("5 0 R")}, "draws itself"},
+		{"forms drawing each other", "<< /XObject << /X0 5 0 R >> >>", "/X0 Do", []string{form("6 0 R"), form("5 0 R")}, "draws itself"},
+		{"function array containing itself", separation, "/CS0 cs 1 scn 0 0 10 10 re f", []string{"[5 0 R]"}, "nest too deeply"},
+		{
+			"stitching function containing itself", separation, "/CS0 cs 1 scn 0 0 10 10 re f",
+			[]string{"<< /FunctionType 3 /Domain [0 1] /Functions [5 0 R] /Bounds [] /Encode [0 1] >>"}, "nest too deeply",
+		},
+		{
+			"function referencing others many times", separation, "/CS0 cs 1 scn 0 0 10 10 re f",
+			[]string{wideFunction("6 0 R"), wideFunction("7 0 R"), wideFunction("8 0 R"), wideFunction("9 0 R"), exponential}, "more than",
+		},
+	}
+
+	for _, test := range tests {
+		pdfBytes := buildTestPdf(10, 10, test.resources, test.content, test.extraObjects...)
+
+		if _, err := renderPdfPage(pdfBytes, 1, 72); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: error = %v, want one containing %q", test.name, err, test.wantErr)
+		}
+	}
+}
+
+func TestRenderPdfPageRotation(t *testing.T) {
+	red := color.RGBA{255, 0, 0, 255}
+
+	// a 40x20pt page with a red square in its bottom left corner
+	tests := []struct {
+		rotation      string
+		width, height int
+		redX, redY    int
+	}{
+		{"", 40, 20, 5, 15},
+		{"/Rotate 90", 20, 40, 5, 5},
+		{"/Rotate 180", 40, 20, 35, 5},
+		{"/Rotate 270", 20, 40, 15, 35},
+		{"/Rotate -90", 20, 40, 15, 35},
+	}
+
+	for _, test := range tests {
+		pdfBytes := buildTestPdfWithPageKeys(40, 20, test.rotation, "<< >>", "1 0 0 rg 0 0 10 10 re f")
+
+		img, err := renderPdfPage(pdfBytes, 1, 72)
+		if err != nil {
+			t.Errorf("%q: %v", test.rotation, err)
+			continue
+		}
+
+		if size := img.Bounds().Size(); size.X != test.width || size.Y != test.height {
+			t.Errorf("%q: size %v, want %dx%d", test.rotation, size, test.width, test.height)
+			continue
+		}
+
+		if got := img.RGBAAt(test.redX, test.redY); colorDistance(got, red) > 2 {
+			t.Errorf("%q: pixel %d, %d = %v, want red", test.rotation, test.redX, test.redY, got)
+		}
+
+		// the diagonally opposite corner stays white
+		if got := img.RGBAAt(test.width-1-test.redX, test.height-1-test.redY); colorDistance(got, red) <= 2 {
+			t.Errorf("%q: opposite corner is red too", test.rotation)
+		}
+	}
+}
@@ -1,22 +1,37 @@
 package main
 
 import (
-	"bytes"
-	"encoding/csv"
 	"fmt"
 	"image"
 	"io"
 	"math"
-	"slices"
-	"strconv"
 	"strings"
 	"sync"
 )
 
-func submitPointsOfInterestFromCsv(submittedFile io.Reader, data SubmitPointsOfInterestFromCsvData) (*image.RGBA, error) {
-	submittedPointsOfInterest, err := readPointsOfInterestFromCsv(submittedFile, data.LatCol, data.LongCol, data.WeightCol)
+func submitPointsOfInterestFromCsv(submittedFile io.Reader, data SubmitPointsOfInterestFromCsvData) (*image.RGBA, CsvReport, error) {
+	var geocoder Geocoder
+	if data.AddressCol != nil && *data.AddressCol != "" {
+		gazetteerGeocoder, err := getGazetteerGeocoder()
+		if err != nil {
+			return nil, CsvReport{}, err
+		}
+
+		geocoder = gazetteerGeocoder
+	}
+
+	submittedPointsOfInterest, report, err := readPointsOfInterestFromCsv(submittedFile, data, geocoder)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read latlongs CSV: %w", err)
+		return nil, report, fmt.Errorf("failed to read latlongs CSV: %w", err)
+	}
+
+	if len(report.UnresolvedRows) > 0 && !data.SkipUnresolved {
+		queries := []string{}
+		for _, unresolvedRow := range report.UnresolvedRows {
+			queries = append(queries, fmt.Sprintf("%q (row %d)", unresolvedRow.Query, unresolvedRow.Row))
+		}
+
+		return nil, report, fmt.Errorf("could not geocode %s", strings.Join(queries, ", "))
 	}
 
 	newData := SubmitPointsOfInterestData{
@@ -26,7 +41,8 @@ func submitPointsOfInterestFromCsv(submittedFile io.Reader, data SubmitPointsOfI
 		MaxThresholdRadiusMiles: data.MaxThresholdRadiusMiles,
 	}
 
-	return submitPointsOfInterest(newData)
+	newImg, err := submitPointsOfInterest(newData)
+	return newImg, report, err
 }
 
 func submitPointsOfInterest(data SubmitPointsOfInterestData) (*image.RGBA, error) {
@@ -35,40 +51,50 @@ func submitPointsOfInterest(data SubmitPointsOfInterestData) (*image.RGBA, error
 		return nil, err
 	}
 
+	for _, pointOfInterest := range data.PointsOfInterest {
+		if weight := pointOfInterestWeight(pointOfInterest); weight <= 0 {
+			return nil, fmt.Errorf("weight %f of point of interest at %f, %f must be positive", weight, pointOfInterest.LatLong.Lat, pointOfInterest.LatLong.Long)
+		}
+
+		minRadiusMiles, maxRadiusMiles := pointOfInterestRadiiMiles(pointOfInterest, data)
+		if minRadiusMiles < 0 || maxRadiusMiles <= minRadiusMiles {
+			return nil, fmt.Errorf("radii %f to %f miles of point of interest at %f, %f must be non-negative and increasing", minRadiusMiles, maxRadiusMiles, pointOfInterest.LatLong.Lat, pointOfInterest.LatLong.Long)
+		}
+	}
+
 	overlayBounds := overlayMapImg.Bounds()
 
 	newImg := image.NewRGBA(image.Rect(0, 0, overlayBounds.Max.X, overlayBounds.Max.Y))
 
 	gapX, gapY := getOverlayLatLongGaps(overlayBounds.Max.X, overlayBounds.Max.Y, overlayLatLongBounds)
 
-	minThresholdRadiusDeg := data.MinThresholdRadiusMiles / MilesPerLatLongDegree
-	maxThresholdRadiusDeg := data.MaxThresholdRadiusMiles / MilesPerLatLongDegree
-
 	var wg sync.WaitGroup
 	for y := range overlayBounds.Max.Y {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
 			for x := range overlayBounds.Max.X {
-				r, g, b, a := overlayMapImg.At(x, y).RGBA()
-				isRelevant := r == 0 && g == 0 && b == 0 && a != 0
+				isRelevant := isWithinOverlay(overlayMapImg, x, y)
 
 				newColor := image.Transparent.C
 				if isRelevant {
 					lat, long := getLatLong(x, y, gapX, gapY, overlayLatLongBounds)
 
-					minDist := math.MaxFloat64
-					var closestPointOfInterest PointOfInterest
+					// each point has its own radii so the nearest point isn't necessarily the best one
+					value := 1.0
 					for _, pointOfInterest := range data.PointsOfInterest {
-						dLat := math.Abs(lat-pointOfInterest.LatLong.Lat) / pointOfInterest.Weight
-						dLong := math.Abs(long-pointOfInterest.LatLong.Long) / pointOfInterest.Weight
+						weight := pointOfInterestWeight(pointOfInterest)
+						dLat := math.Abs(lat-pointOfInterest.LatLong.Lat) / weight
+						dLong := math.Abs(long-pointOfInterest.LatLong.Long) / weight
 						dist := math.Sqrt(dLat*dLat + dLong*dLong)
 
-						minDist = math.Min(minDist, dist)
-						closestPointOfInterest = pointOfInterest
-					}
+						minRadiusMiles, maxRadiusMiles := pointOfInterestRadiiMiles(pointOfInterest, data)
+						minThresholdRadiusDeg := minRadiusMiles / MilesPerLatLongDegree
+						maxThresholdRadiusDeg := maxRadiusMiles / MilesPerLatLongDegree
 
-					value := clampedInverseLerp(minThresholdRadiusDeg*closestPointOfInterest.Weight, maxThresholdRadiusDeg*closestPointOfInterest.Weight, minDist)
+						pointValue := clampedInverseLerp(minThresholdRadiusDeg*weight, maxThresholdRadiusDeg*weight, dist)
+						value = math.Min(value, pointValue)
+					}
 
 					newColor = valueColor(value)
 				}
@@ -83,71 +109,261 @@ func submitPointsOfInterest(data SubmitPointsOfInterestData) (*image.RGBA, error
 	return newImg, nil
 }
 
-func readPointsOfInterestFromCsv(submittedFile io.Reader, latCol, longCol string, weightCol *string) ([]PointOfInterest, error) {
-	var buf bytes.Buffer
-	bytesRead, err := buf.ReadFrom(submittedFile)
-	if err != nil {
-		return nil, fmt.Errorf("oops on read sf")
+// pointOfInterestWeight defaults a missing weight to 1
+func pointOfInterestWeight(pointOfInterest PointOfInterest) float64 {
+	if pointOfInterest.Weight == nil {
+		return 1
 	}
 
-	if bytesRead > 10_000_000 {
-		return nil, fmt.Errorf("max file size of 10MB exceeded")
+	return *pointOfInterest.Weight
+}
+
+// pointOfInterestRadiiMiles gives the point's own radii where set, falling back to the dataset wide ones
+func pointOfInterestRadiiMiles(pointOfInterest PointOfInterest, data SubmitPointsOfInterestData) (float64, float64) {
+	minRadiusMiles, maxRadiusMiles := data.MinThresholdRadiusMiles, data.MaxThresholdRadiusMiles
+	if pointOfInterest.MinThresholdRadiusMiles != nil {
+		minRadiusMiles = *pointOfInterest.MinThresholdRadiusMiles
+	}
+	if pointOfInterest.MaxThresholdRadiusMiles != nil {
+		maxRadiusMiles = *pointOfInterest.MaxThresholdRadiusMiles
 	}
 
-	csvReader := csv.NewReader(&buf)
-	rows, err := csvReader.ReadAll()
+	return minRadiusMiles, maxRadiusMiles
+}
+
+func readPointsOfInterestFromCsv(submittedFile io.Reader, data SubmitPointsOfInterestFromCsvData, geocoder Geocoder) ([]PointOfInterest, CsvReport, error) {
+	report := CsvReport{RowErrors: []CsvRowError{}, UnresolvedRows: []UnresolvedRow{}}
+
+	csvReader, err := newCsvReader(submittedFile, data.SkipBadRows)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read CSV from file: %w", err)
+		return nil, report, err
 	}
 
-	if len(rows) < 1 {
-		return nil, fmt.Errorf("read csv unexpectedly empty")
-	}
+	header := csvReader.Header()
 
-	header := rows[0]
-	latI := slices.Index(header, latCol)
-	if latI == -1 {
-		return nil, fmt.Errorf("lat col unexpectedly not found. Found %s", strings.Join(header, ", "))
+	addressI := -1
+	if geocoder != nil {
+		addressI = csvReader.ColumnIndex(*data.AddressCol)
+		if addressI == -1 {
+			return nil, report, fmt.Errorf("address col unexpectedly not found. Found %s", strings.Join(header, ", "))
+		}
 	}
 
-	longI := slices.Index(header, longCol)
-	if longI == -1 {
-		return nil, fmt.Errorf("long col unexpectedly not found")
+	// lat/long columns are optional when every row can be geocoded from its address instead
+	latI, longI := -1, -1
+	if addressI == -1 || data.LatCol != "" || data.LongCol != "" {
+		latI = csvReader.ColumnIndex(data.LatCol)
+		if latI == -1 {
+			return nil, report, fmt.Errorf("lat col unexpectedly not found. Found %s", strings.Join(header, ", "))
+		}
+
+		longI = csvReader.ColumnIndex(data.LongCol)
+		if longI == -1 {
+			return nil, report, fmt.Errorf("long col unexpectedly not found")
+		}
 	}
 
 	weightI := -1
-	if weightCol != nil && *weightCol != "" {
-		weightI = slices.Index(header, *weightCol)
+	if data.WeightCol != nil && *data.WeightCol != "" {
+		weightI = csvReader.ColumnIndex(*data.WeightCol)
 		if weightI == -1 {
-			return nil, fmt.Errorf("weight col unexpectedly not found")
+			return nil, report, fmt.Errorf("weight col unexpectedly not found")
+		}
+	}
+
+	weightCategoryI := -1
+	if data.WeightCategoryCol != nil && *data.WeightCategoryCol != "" {
+		if weightI != -1 {
+			return nil, report, fmt.Errorf("only one of weight col and weight category col may be given")
+		}
+
+		weightCategoryI = csvReader.ColumnIndex(*data.WeightCategoryCol)
+		if weightCategoryI == -1 {
+			return nil, report, fmt.Errorf("weight category col unexpectedly not found")
+		}
+
+		for category, weight := range data.WeightByCategory {
+			if weight <= 0 {
+				return nil, report, fmt.Errorf("weight %f for category %s must be positive", weight, category)
+			}
+		}
+	}
+
+	minRadiusI := -1
+	if data.MinRadiusCol != nil && *data.MinRadiusCol != "" {
+		minRadiusI = csvReader.ColumnIndex(*data.MinRadiusCol)
+		if minRadiusI == -1 {
+			return nil, report, fmt.Errorf("min radius col unexpectedly not found")
+		}
+	}
+
+	maxRadiusI := -1
+	if data.MaxRadiusCol != nil && *data.MaxRadiusCol != "" {
+		maxRadiusI = csvReader.ColumnIndex(*data.MaxRadiusCol)
+		if maxRadiusI == -1 {
+			return nil, report, fmt.Errorf("max radius col unexpectedly not found")
 		}
 	}
 
 	result := []PointOfInterest{}
-	for _, row := range rows[1:] {
-		lat, err := strconv.ParseFloat(row[latI], 64)
+	for {
+		row, err := csvReader.Read()
+		if err == io.EOF {
+			break
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to parse lat %s to float: %w", row[latI], err)
+			report.RowErrors = csvReader.RowErrors()
+			return nil, report, err
 		}
 
-		long, err := strconv.ParseFloat(row[longI], 64)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse long %s to float: %w", row[longI], err)
+		var latLong LatLong
+		if latI != -1 && (addressI == -1 || row[latI] != "" || row[longI] != "") {
+			lat, ok, err := csvReader.ParseCoordinate(row, latI)
+			if err != nil {
+				report.RowErrors = csvReader.RowErrors()
+				return nil, report, err
+			}
+			if !ok {
+				continue
+			}
+
+			long, ok, err := csvReader.ParseCoordinate(row, longI)
+			if err != nil {
+				report.RowErrors = csvReader.RowErrors()
+				return nil, report, err
+			}
+			if !ok {
+				continue
+			}
+
+			latLong = LatLong{Lat: lat, Long: long}
+		} else {
+			var found bool
+			latLong, found = geocoder.Geocode(row[addressI])
+			if !found {
+				report.UnresolvedRows = append(report.UnresolvedRows, UnresolvedRow{Row: csvReader.RowNum(), Query: row[addressI]})
+				continue
+			}
 		}
 
 		weight := 1.0
-		if weightI != 1 {
-			weight, err = strconv.ParseFloat(row[weightI], 64)
+		if weightI != -1 {
+			var ok bool
+			weight, ok, err = csvReader.ParseFloat(row, weightI)
+			if err != nil {
+				report.RowErrors = csvReader.RowErrors()
+				return nil, report, err
+			}
+			if !ok {
+				continue
+			}
+
+			if weight <= 0 {
+				rowErr := csvReader.RowError(CsvRowError{Column: *data.WeightCol, Value: row[weightI], Message: "weight must be positive"})
+				if rowErr != nil {
+					report.RowErrors = csvReader.RowErrors()
+					return nil, report, rowErr
+				}
+				continue
+			}
+		} else if weightCategoryI != -1 {
+			category := strings.TrimSpace(row[weightCategoryI])
+
+			var found bool
+			weight, found = data.WeightByCategory[category]
+			if !found {
+				rowErr := csvReader.RowError(CsvRowError{Column: *data.WeightCategoryCol, Value: row[weightCategoryI], Message: "category has no weight"})
+				if rowErr != nil {
+					report.RowErrors = csvReader.RowErrors()
+					return nil, report, rowErr
+				}
+				continue
+			}
+		}
+
+		// blank radius cells fall back to the dataset wide radii
+		var minRadiusMiles, maxRadiusMiles *float64
+		if minRadiusI != -1 && strings.TrimSpace(row[minRadiusI]) != "" {
+			minRadius, ok, err := csvReader.ParseFloat(row, minRadiusI)
+			if err != nil {
+				report.RowErrors = csvReader.RowErrors()
+				return nil, report, err
+			}
+			if !ok {
+				continue
+			}
+
+			minRadiusMiles = &minRadius
+		}
+
+		if maxRadiusI != -1 && strings.TrimSpace(row[maxRadiusI]) != "" {
+			maxRadius, ok, err := csvReader.ParseFloat(row, maxRadiusI)
 			if err != nil {
-				return nil, fmt.Errorf("failed to parse weight %s to float: %w", row[weightI], err)
+				report.RowErrors = csvReader.RowErrors()
+				return nil, report, err
+			}
+			if !ok {
+				continue
 			}
+
+			maxRadiusMiles = &maxRadius
 		}
 
 		result = append(result, PointOfInterest{
-			LatLong: LatLong{Lat: lat, Long: long},
-			Weight:  weight,
+			LatLong:                 latLong,
+			Weight:                  &weight,
+			MinThresholdRadiusMiles: minRadiusMiles,
+			MaxThresholdRadiusMiles: maxRadiusMiles,
 		})
 	}
 
-	return result, nil
+	report.RowErrors = csvReader.RowErrors()
+
+	if err := scalePointsOfInterestWeights(result, data.WeightNormalization, data.WeightScale); err != nil {
+		return nil, report, err
+	}
+
+	return result, report, nil
+}
+
+// scalePointsOfInterestWeights rescales weights in place so that either the
+// largest ("max") or the average ("mean") weight becomes 1, then multiplies
+// every weight by scale if given
+func scalePointsOfInterestWeights(pointsOfInterest []PointOfInterest, normalization string, scale *float64) error {
+	if len(pointsOfInterest) == 0 {
+		return nil
+	}
+
+	divisor := 1.0
+	switch normalization {
+	case "", "none":
+	case "max":
+		divisor = 0
+		for _, pointOfInterest := range pointsOfInterest {
+			divisor = math.Max(divisor, pointOfInterestWeight(pointOfInterest))
+		}
+	case "mean":
+		divisor = 0
+		for _, pointOfInterest := range pointsOfInterest {
+			divisor += pointOfInterestWeight(pointOfInterest)
+		}
+		divisor /= float64(len(pointsOfInterest))
+	default:
+		return fmt.Errorf("unknown weight normalization %s, expected none, max or mean", normalization)
+	}
+
+	multiplier := 1.0
+	if scale != nil {
+		if *scale <= 0 {
+			return fmt.Errorf("weight scale %f must be positive", *scale)
+		}
+		multiplier = *scale
+	}
+
+	for i := range pointsOfInterest {
+		weight := pointOfInterestWeight(pointsOfInterest[i]) / divisor * multiplier
+		pointsOfInterest[i].Weight = &weight
+	}
+
+	return nil
 }
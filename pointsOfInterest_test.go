@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestPointOfInterestWeightDefaultsToOne(t *testing.T) {
+	tests := []struct {
+		json string
+		want float64
+	}{
+		{`{"latLong": {"lat": 40.7, "long": -74}}`, 1},
+		{`{"latLong": {"lat": 40.7, "long": -74}, "weight": 2.5}`, 2.5},
+		{`{"latLong": {"lat": 40.7, "long": -74}, "weight": 0}`, 0},
+	}
+
+	for _, test := range tests {
+		var pointOfInterest PointOfInterest
+		if err := json.Unmarshal([]byte(test.json), &pointOfInterest); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := pointOfInterestWeight(pointOfInterest); got != test.want {
+			t.Errorf("weight of %s = %v, want %v", test.json, got, test.want)
+		}
+	}
+}
+
+func TestScalePointsOfInterestWeights(t *testing.T) {
+	weight := func(w float64) *float64 { return &w }
+	scale := 3.0
+
+	tests := []struct {
+		normalization string
+		scale         *float64
+		weights       []*float64
+		want          []float64
+		wantErr       bool
+	}{
+		{"none", nil, []*float64{weight(2), nil}, []float64{2, 1}, false},
+		{"max", nil, []*float64{weight(2), weight(4), nil}, []float64{0.5, 1, 0.25}, false},
+		{"mean", nil, []*float64{weight(1), weight(3)}, []float64{0.5, 1.5}, false},
+		{"max", &scale, []*float64{weight(2), weight(4)}, []float64{1.5, 3}, false},
+		{"median", nil, []*float64{weight(1)}, nil, true},
+	}
+
+	for _, test := range tests {
+		pointsOfInterest := make([]PointOfInterest, len(test.weights))
+		for i, w := range test.weights {
+			pointsOfInterest[i].Weight = w
+		}
+
+		err := scalePointsOfInterestWeights(pointsOfInterest, test.normalization, test.scale)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", test.normalization, err, test.wantErr)
+			continue
+		}
+
+		for i, want := range test.want {
+			if got := pointOfInterestWeight(pointsOfInterest[i]); math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s: weight %d = %v, want %v", test.normalization, i, got, want)
+			}
+		}
+	}
+}
+
+func TestReadPointsOfInterestFromCsvCoordinates(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		want    LatLong
+		wantErr bool
+	}{
+		{"dot decimals", "name,lat,long\na,40.123,-73.987\n", LatLong{Lat: 40.123, Long: -73.987}, false},
+		{"comma decimals with three digits", "name;lat;long\na;40,123;-73,987\n", LatLong{Lat: 40.123, Long: -73.987}, false},
+		{"digit grouped", "name;lat;long\na;1.040,123;-73,987\n", LatLong{}, true},
+	}
+
+	for _, test := range tests {
+		data := SubmitPointsOfInterestFromCsvData{LatCol: "lat", LongCol: "long"}
+		pointsOfInterest, _, err := readPointsOfInterestFromCsv(strings.NewReader(test.csv), data, nil)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+
+		if len(pointsOfInterest) != 1 || pointsOfInterest[0].LatLong != test.want {
+			t.Errorf("%s: got %+v, want one point at %v", test.name, pointsOfInterest, test.want)
+		}
+	}
+}
+
+func TestPointOfInterestRadiiMiles(t *testing.T) {
+	data := SubmitPointsOfInterestData{MinThresholdRadiusMiles: 1, MaxThresholdRadiusMiles: 5}
+
+	tests := []struct {
+		name             string
+		min, max         *float64
+		wantMin, wantMax float64
+	}{
+		{"dataset radii", nil, nil, 1, 5},
+		{"own min", ptr(2.0), nil, 2, 5},
+		{"own max", nil, ptr(8.0), 1, 8},
+		{"own radii", ptr(0.0), ptr(0.5), 0, 0.5},
+	}
+
+	for _, test := range tests {
+		pointOfInterest := PointOfInterest{MinThresholdRadiusMiles: test.min, MaxThresholdRadiusMiles: test.max}
+		if gotMin, gotMax := pointOfInterestRadiiMiles(pointOfInterest, data); gotMin != test.wantMin || gotMax != test.wantMax {
+			t.Errorf("%s: radii %v to %v, want %v to %v", test.name, gotMin, gotMax, test.wantMin, test.wantMax)
+		}
+	}
+}
+
+func TestReadPointsOfInterestFromCsvRadii(t *testing.T) {
+	csv := "lat,long,min,max\n" +
+		"1,1,,\n" +
+		"2,2,0.5,\n" +
+		"3,3,,9\n"
+	data := SubmitPointsOfInterestFromCsvData{LatCol: "lat", LongCol: "long", MinRadiusCol: ptr("min"), MaxRadiusCol: ptr("max")}
+
+	pointsOfInterest, _, err := readPointsOfInterestFromCsv(strings.NewReader(csv), data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// blank cells are left unset so the dataset wide radii apply
+	want := []struct{ min, max *float64 }{{nil, nil}, {ptr(0.5), nil}, {nil, ptr(9.0)}}
+	if len(pointsOfInterest) != len(want) {
+		t.Fatalf("got %d points, want %d", len(pointsOfInterest), len(want))
+	}
+
+	sameRadius := func(a, b *float64) bool { return (a == nil) == (b == nil) && (a == nil || *a == *b) }
+	for i, pointOfInterest := range pointsOfInterest {
+		if !sameRadius(pointOfInterest.MinThresholdRadiusMiles, want[i].min) || !sameRadius(pointOfInterest.MaxThresholdRadiusMiles, want[i].max) {
+			t.Errorf("point %d: radii %v to %v, want %v to %v", i, pointOfInterest.MinThresholdRadiusMiles, pointOfInterest.MaxThresholdRadiusMiles, want[i].min, want[i].max)
+		}
+	}
+}
+
+func TestSubmitPointsOfInterestRadii(t *testing.T) {
+	chdirTemp(t)
+
+	// a row of pixels one degree apart, sampled at their top left corners
+	writeOverlayFixture(t, 3, 1, func(x, y int) bool { return true })
+
+	point := LatLong{Lat: 1, Long: 0}
+	degreesInMiles := func(degrees float64) float64 { return degrees * MilesPerLatLongDegree }
+
+	tests := []struct {
+		name     string
+		min, max *float64
+		want     []float64
+		wantErr  bool
+	}{
+		{"dataset radii", nil, nil, []float64{0, 0.25, 0.5}, false},
+		{"own max radius", nil, ptr(degreesInMiles(2)), []float64{0, 0.5, 1}, false},
+		{"own min radius", ptr(degreesInMiles(1)), nil, []float64{0, 0, 1.0 / 3}, false},
+		{"own max within the dataset min", nil, ptr(0.0), nil, true},
+	}
+
+	for _, test := range tests {
+		data := SubmitPointsOfInterestData{
+			PointsOfInterest:        []PointOfInterest{{LatLong: point, MinThresholdRadiusMiles: test.min, MaxThresholdRadiusMiles: test.max}},
+			MinThresholdRadiusMiles: 0,
+			MaxThresholdRadiusMiles: degreesInMiles(4),
+		}
+
+		img, err := submitPointsOfInterest(data)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%s: error = %v, wantErr %v", test.name, err, test.wantErr)
+			continue
+		}
+		if test.wantErr {
+			continue
+		}
+
+		for x, value := range test.want {
+			if got, want := img.RGBAAt(x, 0), valueColor(value); got != want {
+				t.Errorf("%s: pixel %d = %v, want %v", test.name, x, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+type ProjectionInfo struct {
+	// one of equirectangular (the default), webMercator, albers or utm
+	Kind string `json:"kind"`
+	// albers parameters, defaulting to the conterminous US national map ones
+	StandardParallel1 *float64 `json:"standardParallel1"`
+	StandardParallel2 *float64 `json:"standardParallel2"`
+	LatitudeOfOrigin  *float64 `json:"latitudeOfOrigin"`
+	CentralMeridian   *float64 `json:"centralMeridian"`
+	// utm parameters
+	UtmZone  int  `json:"utmZone"`
+	UtmSouth bool `json:"utmSouth"`
+}
+
+// Projection converts between lat/long in degrees and planar map coordinates,
+// with x increasing to the east and y increasing to the north
+type Projection interface {
+	Forward(lat, long float64) (float64, float64)
+	Inverse(x, y float64) (float64, float64)
+}
+
+// WGS84 ellipsoid
+const (
+	earthSemiMajorAxis  float64 = 6378137.0
+	earthEccentricitySq float64 = 0.00669437999014
+)
+
+func newProjection(info *ProjectionInfo) (Projection, error) {
+	if info == nil {
+		return EquirectangularProjection{}, nil
+	}
+
+	switch info.Kind {
+	case "", "equirectangular":
+		return EquirectangularProjection{}, nil
+	case "webMercator":
+		return WebMercatorProjection{}, nil
+	case "albers":
+		return newAlbersProjection(
+			valueOr(info.StandardParallel1, 29.5),
+			valueOr(info.StandardParallel2, 45.5),
+			valueOr(info.LatitudeOfOrigin, 23),
+			valueOr(info.CentralMeridian, -96),
+		)
+	case "utm":
+		if info.UtmZone < 1 || info.UtmZone > 60 {
+			return nil, fmt.Errorf("utm zone %d must be between 1 and 60", info.UtmZone)
+		}
+		return UtmProjection{Zone: info.UtmZone, South: info.UtmSouth}, nil
+	default:
+		return nil, fmt.Errorf("unknown projection %s, expected equirectangular, webMercator, albers or utm", info.Kind)
+	}
+}
+
+func isEquirectangular(projection Projection) bool {
+	_, isEquirectangular := projection.(EquirectangularProjection)
+	return projection == nil || isEquirectangular
+}
+
+type EquirectangularProjection struct{}
+
+func (EquirectangularProjection) Forward(lat, long float64) (float64, float64) {
+	return long, lat
+}
+
+func (EquirectangularProjection) Inverse(x, y float64) (float64, float64) {
+	return y, x
+}
+
+type WebMercatorProjection struct{}
+
+// beyond this latitude web mercator maps are cut off to make the world square
+const webMercatorMaxLat float64 = 85.05112878
+
+func (WebMercatorProjection) Forward(lat, long float64) (float64, float64) {
+	lat = math.Max(math.Min(lat, webMercatorMaxLat), -webMercatorMaxLat)
+	x := earthSemiMajorAxis * degToRad(long)
+	y := earthSemiMajorAxis * math.Log(math.Tan(math.Pi/4+degToRad(lat)/2))
+	return x, y
+}
+
+func (WebMercatorProjection) Inverse(x, y float64) (float64, float64) {
+	lat := radToDeg(2*math.Atan(math.Exp(y/earthSemiMajorAxis)) - math.Pi/2)
+	long := radToDeg(x / earthSemiMajorAxis)
+	return lat, long
+}
+
+// AlbersProjection is the ellipsoidal Albers equal area conic, following Snyder's
+// "Map Projections: A Working Manual" (1987) pp. 98-103
+type AlbersProjection struct {
+	centralMeridian float64
+	n, c, rho0      float64
+}
+
+func newAlbersProjection(standardParallel1, standardParallel2, latitudeOfOrigin, centralMeridian float64) (AlbersProjection, error) {
+	if standardParallel1 == -standardParallel2 {
+		return AlbersProjection{}, fmt.Errorf("albers standard parallels must not be symmetric about the equator")
+	}
+
+	phi1, phi2, phi0 := degToRad(standardParallel1), degToRad(standardParallel2), degToRad(latitudeOfOrigin)
+
+	m1, m2 := albersM(phi1), albersM(phi2)
+	q1, q2, q0 := albersQ(phi1), albersQ(phi2), albersQ(phi0)
+
+	var n float64
+	if standardParallel1 == standardParallel2 {
+		n = math.Sin(phi1)
+	} else {
+		n = (m1*m1 - m2*m2) / (q2 - q1)
+	}
+
+	c := m1*m1 + n*q1
+	rho0 := earthSemiMajorAxis * math.Sqrt(c-n*q0) / n
+
+	return AlbersProjection{centralMeridian: degToRad(centralMeridian), n: n, c: c, rho0: rho0}, nil
+}
+
+func albersM(phi float64) float64 {
+	sinPhi := math.Sin(phi)
+	return math.Cos(phi) / math.Sqrt(1-earthEccentricitySq*sinPhi*sinPhi)
+}
+
+func albersQ(phi float64) float64 {
+	e := math.Sqrt(earthEccentricitySq)
+	sinPhi := math.Sin(phi)
+	return (1 - earthEccentricitySq) * (sinPhi/(1-earthEccentricitySq*sinPhi*sinPhi) - (1/(2*e))*math.Log((1-e*sinPhi)/(1+e*sinPhi)))
+}
+
+func (p AlbersProjection) Forward(lat, long float64) (float64, float64) {
+	rho := earthSemiMajorAxis * math.Sqrt(p.c-p.n*albersQ(degToRad(lat))) / p.n
+	theta := p.n * (degToRad(long) - p.centralMeridian)
+	return rho * math.Sin(theta), p.rho0 - rho*math.Cos(theta)
+}
+
+func (p AlbersProjection) Inverse(x, y float64) (float64, float64) {
+	rho := math.Hypot(x, p.rho0-y)
+	theta := math.Atan2(x, p.rho0-y)
+	if p.n < 0 {
+		rho = -rho
+		theta = math.Atan2(-x, -(p.rho0 - y))
+	}
+
+	q := (p.c - rho*rho*p.n*p.n/(earthSemiMajorAxis*earthSemiMajorAxis)) / p.n
+
+	e := math.Sqrt(earthEccentricitySq)
+	phi := math.Asin(math.Max(math.Min(q/2, 1), -1))
+	for range 10 {
+		sinPhi := math.Sin(phi)
+		oneMinusESinSq := 1 - earthEccentricitySq*sinPhi*sinPhi
+		dPhi := oneMinusESinSq * oneMinusESinSq / (2 * math.Cos(phi)) *
+			(q/(1-earthEccentricitySq) - sinPhi/oneMinusESinSq + (1/(2*e))*math.Log((1-e*sinPhi)/(1+e*sinPhi)))
+		phi += dPhi
+		if math.Abs(dPhi) < 1e-12 {
+			break
+		}
+	}
+
+	return radToDeg(phi), radToDeg(p.centralMeridian + theta/p.n)
+}
+
+// UtmProjection is transverse mercator on the given UTM zone, following Snyder pp. 60-64
+type UtmProjection struct {
+	Zone  int
+	South bool
+}
+
+const utmScaleFactor float64 = 0.9996
+
+func (p UtmProjection) centralMeridian() float64 {
+	return degToRad(float64(p.Zone-1)*6 - 180 + 3)
+}
+
+func utmMeridianDistance(phi float64) float64 {
+	e2 := earthEccentricitySq
+	e4 := e2 * e2
+	e6 := e4 * e2
+	return earthSemiMajorAxis * ((1-e2/4-3*e4/64-5*e6/256)*phi -
+		(3*e2/8+3*e4/32+45*e6/1024)*math.Sin(2*phi) +
+		(15*e4/256+45*e6/1024)*math.Sin(4*phi) -
+		(35*e6/3072)*math.Sin(6*phi))
+}
+
+func (p UtmProjection) Forward(lat, long float64) (float64, float64) {
+	phi := degToRad(lat)
+	ePrimeSq := earthEccentricitySq / (1 - earthEccentricitySq)
+
+	sinPhi, cosPhi, tanPhi := math.Sin(phi), math.Cos(phi), math.Tan(phi)
+	n := earthSemiMajorAxis / math.Sqrt(1-earthEccentricitySq*sinPhi*sinPhi)
+	t := tanPhi * tanPhi
+	c := ePrimeSq * cosPhi * cosPhi
+	a := (degToRad(long) - p.centralMeridian()) * cosPhi
+
+	x := utmScaleFactor*n*(a+(1-t+c)*math.Pow(a, 3)/6+(5-18*t+t*t+72*c-58*ePrimeSq)*math.Pow(a, 5)/120) + 500000
+	y := utmScaleFactor * (utmMeridianDistance(phi) + n*tanPhi*(a*a/2+(5-t+9*c+4*c*c)*math.Pow(a, 4)/24+(61-58*t+t*t+600*c-330*ePrimeSq)*math.Pow(a, 6)/720))
+	if p.South {
+		y += 10000000
+	}
+
+	return x, y
+}
+
+func (p UtmProjection) Inverse(x, y float64) (float64, float64) {
+	e2 := earthEccentricitySq
+	ePrimeSq := e2 / (1 - e2)
+
+	if p.South {
+		y -= 10000000
+	}
+
+	m := y / utmScaleFactor
+	mu := m / (earthSemiMajorAxis * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+	phi1 := mu + (3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	sinPhi1, cosPhi1, tanPhi1 := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+	c1 := ePrimeSq * cosPhi1 * cosPhi1
+	t1 := tanPhi1 * tanPhi1
+	n1 := earthSemiMajorAxis / math.Sqrt(1-e2*sinPhi1*sinPhi1)
+	r1 := earthSemiMajorAxis * (1 - e2) / math.Pow(1-e2*sinPhi1*sinPhi1, 1.5)
+	d := (x - 500000) / (n1 * utmScaleFactor)
+
+	phi := phi1 - (n1*tanPhi1/r1)*(d*d/2-(5+3*t1+10*c1-4*c1*c1-9*ePrimeSq)*math.Pow(d, 4)/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ePrimeSq-3*c1*c1)*math.Pow(d, 6)/720)
+	lambda := p.centralMeridian() + (d-(1+2*t1+c1)*math.Pow(d, 3)/6+
+		(5-2*c1+28*t1-3*c1*c1+8*ePrimeSq+24*t1*t1)*math.Pow(d, 5)/120)/cosPhi1
+
+	return radToDeg(phi), radToDeg(lambda)
+}
+
+// projectedPosition gives where a lat/long falls within the box spanned by the
+// projected top left and bottom right corners, as fractions of its width and height
+func projectedPosition(projection Projection, bounds OverlayBounds, lat, long float64) (float64, float64) {
+	tlX, tlY := projection.Forward(bounds.TopLeft.Lat, bounds.TopLeft.Long)
+	brX, brY := projection.Forward(bounds.BottomRight.Lat, bounds.BottomRight.Long)
+	x, y := projection.Forward(lat, long)
+
+	return (x - tlX) / (brX - tlX), (y - tlY) / (brY - tlY)
+}
+
+func degToRad(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+func radToDeg(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+func valueOr(val *float64, fallback float64) float64 {
+	if val == nil {
+		return fallback
+	}
+
+	return *val
+}
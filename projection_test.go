@@ -0,0 +1,94 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestProjectionKnownValues(t *testing.T) {
+	albers, err := newAlbersProjection(29.5, 45.5, 23, -96)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		projection   Projection
+		lat, long    float64
+		wantX, wantY float64
+		tolerance    float64
+	}{
+		{"equirectangular", EquirectangularProjection{}, 40.5, -74.25, -74.25, 40.5, 1e-12},
+		{"web mercator origin", WebMercatorProjection{}, 0, 0, 0, 0, 1e-6},
+		{"web mercator corner", WebMercatorProjection{}, webMercatorMaxLat, 180, 20037508.34, 20037508.34, 0.01},
+		{"web mercator clamps", WebMercatorProjection{}, 89, -180, -20037508.34, 20037508.34, 0.01},
+		{"albers origin", albers, 23, -96, 0, 0, 1e-6},
+		{"utm central meridian at the equator", UtmProjection{Zone: 18}, 0, -75, 500000, 0, 1e-6},
+		// the WGS84 meridian arc to 45 degrees is 4984944.378 m
+		{"utm central meridian at 45", UtmProjection{Zone: 18}, 45, -75, 500000, 0.9996 * 4984944.378, 0.01},
+		{"utm south false northing", UtmProjection{Zone: 33, South: true}, 0, 15, 500000, 10000000, 1e-6},
+	}
+
+	for _, test := range tests {
+		x, y := test.projection.Forward(test.lat, test.long)
+		if math.Abs(x-test.wantX) > test.tolerance || math.Abs(y-test.wantY) > test.tolerance {
+			t.Errorf("%s: Forward(%v, %v) = %v, %v, want %v, %v", test.name, test.lat, test.long, x, y, test.wantX, test.wantY)
+		}
+	}
+}
+
+func TestProjectionRoundTrips(t *testing.T) {
+	albers, err := newAlbersProjection(29.5, 45.5, 23, -96)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	southernAlbers, err := newAlbersProjection(-18, -36, 0, 132)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		projection Projection
+		latLongs   []LatLong
+	}{
+		{"equirectangular", EquirectangularProjection{}, []LatLong{{40.7, -74}, {-33.9, 151.2}}},
+		{"web mercator", WebMercatorProjection{}, []LatLong{{40.7, -74}, {-33.9, 151.2}, {84, 179}}},
+		{"albers", albers, []LatLong{{40.7, -74}, {25.8, -80.2}, {47.6, -122.3}, {23, -96}}},
+		{"southern albers", southernAlbers, []LatLong{{-33.9, 151.2}, {-12.5, 130.8}}},
+		{"utm north", UtmProjection{Zone: 18}, []LatLong{{40.7, -74}, {44.9, -77.9}, {0.1, -75}}},
+		{"utm south", UtmProjection{Zone: 56, South: true}, []LatLong{{-33.9, 151.2}, {-28, 153.4}}},
+	}
+
+	for _, test := range tests {
+		for _, latLong := range test.latLongs {
+			x, y := test.projection.Forward(latLong.Lat, latLong.Long)
+			lat, long := test.projection.Inverse(x, y)
+			if math.Abs(lat-latLong.Lat) > 1e-7 || math.Abs(long-latLong.Long) > 1e-7 {
+				t.Errorf("%s: %v round tripped to %v, %v", test.name, latLong, lat, long)
+			}
+		}
+	}
+}
+
+func TestNewProjection(t *testing.T) {
+	tests := []struct {
+		info    *ProjectionInfo
+		wantErr bool
+	}{
+		{nil, false},
+		{&ProjectionInfo{Kind: "webMercator"}, false},
+		{&ProjectionInfo{Kind: "albers"}, false},
+		{&ProjectionInfo{Kind: "utm", UtmZone: 18}, false},
+		{&ProjectionInfo{Kind: "utm", UtmZone: 61}, true},
+		{&ProjectionInfo{Kind: "albers", StandardParallel1: ptr(30.0), StandardParallel2: ptr(-30.0)}, true},
+		{&ProjectionInfo{Kind: "lambert"}, true},
+	}
+
+	for _, test := range tests {
+		if _, err := newProjection(test.info); (err != nil) != test.wantErr {
+			t.Errorf("newProjection(%+v) error = %v, wantErr %v", test.info, err, test.wantErr)
+		}
+	}
+}
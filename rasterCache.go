@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"sync"
+	"time"
+)
+
+// decoded stored maps by tag, dropped when a tag's map is confirmed again. A tag's
+// generation is bumped on every drop so a decode that started before it isn't cached.
+var storedMapCache = struct {
+	sync.Mutex
+	maps        map[string]storedMap
+	generations map[string]uint64
+}{maps: map[string]storedMap{}, generations: map[string]uint64{}}
+
+// storedMap is a decoded map along with the file it was decoded from, so a map
+// replaced on disk by anything other than confirmation is still reloaded
+type storedMap struct {
+	img     *image.RGBA
+	modTime time.Time
+	size    int64
+}
+
+// the decoded overlay, dropped when the overlay is regenerated
+var overlayCache = struct {
+	sync.Mutex
+	mask *OverlayMask
+}{}
+
+// OverlayMask is the decoded overlay image along with a bitset of which of its
+// pixels are within the overlay, so lookups skip the color conversion of At
+type OverlayMask struct {
+	image.Image
+	width, height int
+	bits          []uint64
+}
+
+func newOverlayMask(overlayImg image.Image) *OverlayMask {
+	bounds := overlayImg.Bounds()
+	mask := &OverlayMask{Image: overlayImg, width: bounds.Max.X, height: bounds.Max.Y}
+	mask.bits = make([]uint64, (mask.width*mask.height+63)/64)
+
+	for y := range mask.height {
+		for x := range mask.width {
+			r, g, b, a := overlayImg.At(x, y).RGBA()
+			if r == 0 && g == 0 && b == 0 && a != 0 {
+				i := y*mask.width + x
+				mask.bits[i/64] |= 1 << (i % 64)
+			}
+		}
+	}
+
+	return mask
+}
+
+func (m *OverlayMask) Contains(x, y int) bool {
+	if x < 0 || y < 0 || x >= m.width || y >= m.height {
+		return false
+	}
+
+	i := y*m.width + x
+	return m.bits[i/64]&(1<<(i%64)) != 0
+}
+
+// getStoredMap decodes the tag's confirmed map, or reuses it if already decoded and unchanged on disk.
+// Decoding happens outside the lock so maps for different tags decode in parallel.
+func getStoredMap(tag string) (*image.RGBA, error) {
+	filename := "./database/maps/" + tag + ".png"
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	storedMapCache.Lock()
+	cached, found := storedMapCache.maps[tag]
+	generation := storedMapCache.generations[tag]
+	storedMapCache.Unlock()
+	if found && cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+		return cached.img, nil
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	pngFile, err := png.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode map %s: %w", tag, err)
+	}
+
+	img := decodeToRGBA(pngFile)
+	cacheStoredMap(tag, generation, storedMap{img: img, modTime: stat.ModTime(), size: stat.Size()})
+
+	return img, nil
+}
+
+// cacheStoredMap keeps a decode unless the tag was invalidated since its generation was read,
+// in which case the decode may be of the replaced map
+func cacheStoredMap(tag string, generation uint64, entry storedMap) {
+	storedMapCache.Lock()
+	defer storedMapCache.Unlock()
+
+	if storedMapCache.generations[tag] == generation {
+		storedMapCache.maps[tag] = entry
+	}
+}
+
+func invalidateStoredMap(tag string) {
+	storedMapCache.Lock()
+	storedMapCache.generations[tag]++
+	delete(storedMapCache.maps, tag)
+	storedMapCache.Unlock()
+}
+
+func invalidateOverlay() {
+	overlayCache.Lock()
+	overlayCache.mask = nil
+	overlayCache.Unlock()
+}
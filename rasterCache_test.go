@@ -0,0 +1,108 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGetStoredMap(t *testing.T) {
+	chdirTemp(t)
+	if err := os.MkdirAll("./database/maps", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	readStoredMap := func(t *testing.T, tag string) *image.RGBA {
+		t.Helper()
+
+		img, err := getStoredMap(tag)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+
+	writeValue := func(t *testing.T, tag string, g uint8, modTime time.Time) {
+		t.Helper()
+
+		writePngFixture(t, "./database/maps/"+tag+".png", 1, 1, func(x, y int) color.NRGBA { return valuePixel(g) })
+		if err := os.Chtimes("./database/maps/"+tag+".png", modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	start := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name string
+		// changes the map after it's first read
+		change     func(t *testing.T, tag string)
+		want       uint8
+		wantReused bool
+	}{
+		{"unchanged map is reused", func(t *testing.T, tag string) {}, 10, true},
+		{"invalidated map is reloaded", func(t *testing.T, tag string) {
+			writeValue(t, tag, 20, start)
+			invalidateStoredMap(tag)
+		}, 20, false},
+		{"map replaced on disk is reloaded", func(t *testing.T, tag string) {
+			writeValue(t, tag, 30, start.Add(time.Minute))
+		}, 30, false},
+	}
+
+	for i, test := range tests {
+		tag := "cached" + string(rune('A'+i))
+		invalidateStoredMap(tag)
+		t.Cleanup(func() { invalidateStoredMap(tag) })
+
+		writeValue(t, tag, 10, start)
+		first := readStoredMap(t, tag)
+		if got := first.RGBAAt(0, 0).G; got != 10 {
+			t.Errorf("%s: first read %d, want 10", test.name, got)
+		}
+
+		test.change(t, tag)
+		second := readStoredMap(t, tag)
+		if got := second.RGBAAt(0, 0).G; got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+		if (first == second) != test.wantReused {
+			t.Errorf("%s: reused the decode %v, want %v", test.name, first == second, test.wantReused)
+		}
+	}
+}
+
+func TestCacheStoredMapSkipsStaleDecodes(t *testing.T) {
+	tag := "staleDecode"
+	invalidateStoredMap(tag)
+	t.Cleanup(func() { invalidateStoredMap(tag) })
+
+	entry := storedMap{img: image.NewRGBA(image.Rect(0, 0, 1, 1))}
+
+	storedMapCache.Lock()
+	generation := storedMapCache.generations[tag]
+	storedMapCache.Unlock()
+
+	// the map is confirmed again while the old one is still decoding
+	invalidateStoredMap(tag)
+	cacheStoredMap(tag, generation, entry)
+
+	storedMapCache.Lock()
+	_, found := storedMapCache.maps[tag]
+	current := storedMapCache.generations[tag]
+	storedMapCache.Unlock()
+	if found {
+		t.Errorf("decode from before the invalidation was cached")
+	}
+
+	cacheStoredMap(tag, current, entry)
+
+	storedMapCache.Lock()
+	_, found = storedMapCache.maps[tag]
+	storedMapCache.Unlock()
+	if !found {
+		t.Errorf("decode from the current generation wasn't cached")
+	}
+}
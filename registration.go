@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+type ControlPoint struct {
+	ImageX  float64 `json:"imageX"`
+	ImageY  float64 `json:"imageY"`
+	LatLong LatLong `json:"latLong"`
+}
+
+type ControlPointResidual struct {
+	ImageX      float64 `json:"imageX"`
+	ImageY      float64 `json:"imageY"`
+	PredictedX  float64 `json:"predictedX"`
+	PredictedY  float64 `json:"predictedY"`
+	ResidualPxs float64 `json:"residualPxs"`
+}
+
+type RegistrationReport struct {
+	Transform     string                 `json:"transform"`
+	Residuals     []ControlPointResidual `json:"residuals"`
+	RmsResidualPx float64                `json:"rmsResidualPx"`
+	MaxResidualPx float64                `json:"maxResidualPx"`
+}
+
+// ImageRegistration maps a lat/long onto a pixel of the submitted image
+type ImageRegistration struct {
+	// the submitted image's projection, which the fit is linear (or projective) in
+	projection Projection
+	// coefficients of x' = (c0*x + c1*y + c2) / (c6*x + c7*y + 1), y' = (c3*x + c4*y + c5) / (c6*x + c7*y + 1),
+	// where c6 = c7 = 0 for an affine transform
+	coeffs [8]float64
+	// projected and image coordinates are centered and scaled before fitting to keep the equations well conditioned
+	originX, originY, scale                float64
+	imageOriginX, imageOriginY, imageScale float64
+}
+
+func (r ImageRegistration) Apply(lat, long float64) (float64, float64) {
+	projX, projY := r.projection.Forward(lat, long)
+	x := (projX - r.originX) * r.scale
+	y := (projY - r.originY) * r.scale
+	c := r.coeffs
+
+	w := c[6]*x + c[7]*y + 1
+	u := (c[0]*x + c[1]*y + c[2]) / w
+	v := (c[3]*x + c[4]*y + c[5]) / w
+	return r.imageOriginX + u/r.imageScale, r.imageOriginY + v/r.imageScale
+}
+
+// SubmittedImageMapper finds the pixel of a submitted image lying under each overlay pixel
+type SubmittedImageMapper struct {
+	placement                                                    ImagePlacement
+	overlayLatLongBounds                                         OverlayBounds
+	gapX, gapY                                                   float64
+	submittedImagePxPerOverlayPxX, submittedImagePxPerOverlayPxY float64
+	projection                                                   Projection
+	registration                                                 *ImageRegistration
+}
+
+func newSubmittedImageMapper(placement ImagePlacement, overlayBounds image.Rectangle, overlayLatLongBounds OverlayBounds) (SubmittedImageMapper, *RegistrationReport, error) {
+	mapper := SubmittedImageMapper{
+		placement:                     placement,
+		overlayLatLongBounds:          overlayLatLongBounds,
+		submittedImagePxPerOverlayPxX: float64(placement.OverlayLocBottomRightX-placement.OverlayLocTopLeftX) / float64(overlayBounds.Max.X),
+		submittedImagePxPerOverlayPxY: float64(placement.OverlayLocBottomRightY-placement.OverlayLocTopLeftY) / float64(overlayBounds.Max.Y),
+	}
+
+	mapper.gapX, mapper.gapY = getOverlayLatLongGaps(overlayBounds.Max.X, overlayBounds.Max.Y, overlayLatLongBounds)
+
+	var err error
+	mapper.projection, err = newProjection(placement.Projection)
+	if err != nil {
+		return mapper, nil, fmt.Errorf("invalid submitted image projection: %w", err)
+	}
+
+	// control points take precedence over the overlay location box, which can't express stretching or rotation
+	if len(placement.ControlPoints) == 0 {
+		return mapper, nil, nil
+	}
+
+	registration, report, err := fitImageRegistration(placement.ControlPoints, placement.RegistrationTransform, mapper.projection)
+	if err != nil {
+		return mapper, nil, err
+	}
+
+	mapper.registration = &registration
+
+	return mapper, &report, nil
+}
+
+func (m SubmittedImageMapper) SourcePixel(ox, oy int) (int, int) {
+	if m.registration != nil {
+		lat, long := getLatLong(ox, oy, m.gapX, m.gapY, m.overlayLatLongBounds)
+		fx, fy := m.registration.Apply(lat, long)
+		return int(math.Floor(fx)), int(math.Floor(fy))
+	}
+
+	if m.placement.Projection != nil {
+		// the overlay box corners are the overlay bounds corners, positioned in the submitted image's projection
+		lat, long := getLatLong(ox, oy, m.gapX, m.gapY, m.overlayLatLongBounds)
+		fracX, fracY := projectedPosition(m.projection, m.overlayLatLongBounds, lat, long)
+		sx := m.placement.OverlayLocTopLeftX + int(math.Floor(fracX*float64(m.placement.OverlayLocBottomRightX-m.placement.OverlayLocTopLeftX)))
+		sy := m.placement.OverlayLocTopLeftY + int(math.Floor(fracY*float64(m.placement.OverlayLocBottomRightY-m.placement.OverlayLocTopLeftY)))
+		return sx, sy
+	}
+
+	sx := m.placement.OverlayLocTopLeftX + int(float64(ox)*m.submittedImagePxPerOverlayPxX)
+	sy := m.placement.OverlayLocTopLeftY + int(float64(oy)*m.submittedImagePxPerOverlayPxY)
+	return sx, sy
+}
+
+func fitImageRegistration(controlPoints []ControlPoint, kind string, projection Projection) (ImageRegistration, RegistrationReport, error) {
+	var report RegistrationReport
+
+	if kind == "" {
+		kind = "affine"
+	}
+
+	minPoints := 3
+	switch kind {
+	case "affine":
+	case "projective":
+		minPoints = 4
+	default:
+		return ImageRegistration{}, report, fmt.Errorf("unknown registration transform %s, expected affine or projective", kind)
+	}
+
+	if len(controlPoints) < minPoints {
+		return ImageRegistration{}, report, fmt.Errorf("%s registration needs at least %d control points, got %d", kind, minPoints, len(controlPoints))
+	}
+
+	registration := ImageRegistration{projection: projection}
+
+	projected := make([][2]float64, len(controlPoints))
+	for i, controlPoint := range controlPoints {
+		projX, projY := projection.Forward(controlPoint.LatLong.Lat, controlPoint.LatLong.Long)
+		projected[i] = [2]float64{projX, projY}
+		registration.originX += projX
+		registration.originY += projY
+	}
+	registration.originX /= float64(len(controlPoints))
+	registration.originY /= float64(len(controlPoints))
+
+	maxExtent := 0.0
+	for _, p := range projected {
+		maxExtent = math.Max(maxExtent, math.Abs(p[0]-registration.originX))
+		maxExtent = math.Max(maxExtent, math.Abs(p[1]-registration.originY))
+	}
+	if maxExtent == 0 {
+		return ImageRegistration{}, report, fmt.Errorf("control points must not all share the same lat/long")
+	}
+	registration.scale = 1 / maxExtent
+
+	// the projective terms multiply image and projected coordinates, so pixel
+	// coordinates in the thousands would swamp the rest of the normal equations
+	for _, controlPoint := range controlPoints {
+		registration.imageOriginX += controlPoint.ImageX
+		registration.imageOriginY += controlPoint.ImageY
+	}
+	registration.imageOriginX /= float64(len(controlPoints))
+	registration.imageOriginY /= float64(len(controlPoints))
+
+	maxImageExtent := 0.0
+	for _, controlPoint := range controlPoints {
+		maxImageExtent = math.Max(maxImageExtent, math.Abs(controlPoint.ImageX-registration.imageOriginX))
+		maxImageExtent = math.Max(maxImageExtent, math.Abs(controlPoint.ImageY-registration.imageOriginY))
+	}
+	if maxImageExtent == 0 {
+		return ImageRegistration{}, report, fmt.Errorf("control points must not all share the same image pixel")
+	}
+	registration.imageScale = 1 / maxImageExtent
+
+	var a [][]float64
+	var b []float64
+	for i, controlPoint := range controlPoints {
+		x := (projected[i][0] - registration.originX) * registration.scale
+		y := (projected[i][1] - registration.originY) * registration.scale
+		u := (controlPoint.ImageX - registration.imageOriginX) * registration.imageScale
+		v := (controlPoint.ImageY - registration.imageOriginY) * registration.imageScale
+
+		if kind == "affine" {
+			a = append(a, []float64{x, y, 1, 0, 0, 0}, []float64{0, 0, 0, x, y, 1})
+		} else {
+			a = append(a, []float64{x, y, 1, 0, 0, 0, -x * u, -y * u}, []float64{0, 0, 0, x, y, 1, -x * v, -y * v})
+		}
+		b = append(b, u, v)
+	}
+
+	solution, err := solveLeastSquares(a, b)
+	if err != nil {
+		return ImageRegistration{}, report, fmt.Errorf("control points are degenerate (e.g. collinear): %w", err)
+	}
+	copy(registration.coeffs[:], solution)
+
+	report.Transform = kind
+	sumSquares := 0.0
+	for _, controlPoint := range controlPoints {
+		px, py := registration.Apply(controlPoint.LatLong.Lat, controlPoint.LatLong.Long)
+		residual := math.Hypot(px-controlPoint.ImageX, py-controlPoint.ImageY)
+
+		report.Residuals = append(report.Residuals, ControlPointResidual{
+			ImageX:      controlPoint.ImageX,
+			ImageY:      controlPoint.ImageY,
+			PredictedX:  px,
+			PredictedY:  py,
+			ResidualPxs: residual,
+		})
+		sumSquares += residual * residual
+		report.MaxResidualPx = math.Max(report.MaxResidualPx, residual)
+	}
+	report.RmsResidualPx = math.Sqrt(sumSquares / float64(len(controlPoints)))
+
+	return registration, report, nil
+}
+
+// solveLeastSquares minimizes |a*x - b| through the normal equations
+func solveLeastSquares(a [][]float64, b []float64) ([]float64, error) {
+	n := len(a[0])
+
+	ata := make([][]float64, n)
+	atb := make([]float64, n)
+	for i := range n {
+		ata[i] = make([]float64, n)
+		for j := range n {
+			for k := range a {
+				ata[i][j] += a[k][i] * a[k][j]
+			}
+		}
+		for k := range a {
+			atb[i] += a[k][i] * b[k]
+		}
+	}
+
+	return solveLinearSystem(ata, atb)
+}
+
+// solveLinearSystem solves m*x = v by Gaussian elimination with partial pivoting, modifying its inputs.
+// A pivot counts as zero relative to the largest entry so the check doesn't depend on the units of m.
+func solveLinearSystem(m [][]float64, v []float64) ([]float64, error) {
+	n := len(v)
+
+	maxEntry := 0.0
+	for _, row := range m {
+		for _, entry := range row {
+			maxEntry = math.Max(maxEntry, math.Abs(entry))
+		}
+	}
+	tolerance := 1e-12 * maxEntry
+
+	for col := range n {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(m[row][col]) > math.Abs(m[pivot][col]) {
+				pivot = row
+			}
+		}
+
+		if math.Abs(m[pivot][col]) <= tolerance {
+			return nil, fmt.Errorf("singular matrix")
+		}
+
+		m[col], m[pivot] = m[pivot], m[col]
+		v[col], v[pivot] = v[pivot], v[col]
+
+		for row := col + 1; row < n; row++ {
+			factor := m[row][col] / m[col][col]
+			for k := col; k < n; k++ {
+				m[row][k] -= factor * m[col][k]
+			}
+			v[row] -= factor * v[col]
+		}
+	}
+
+	x := make([]float64, n)
+	for row := n - 1; row >= 0; row-- {
+		sum := v[row]
+		for k := row + 1; k < n; k++ {
+			sum -= m[row][k] * x[k]
+		}
+		x[row] = sum / m[row][row]
+	}
+
+	return x, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestFitImageRegistration(t *testing.T) {
+	affine := func(lat, long float64) (float64, float64) {
+		// stretched, rotated and offset deep into a large screenshot
+		x, y := long+74, lat-40.5
+		return 3000 + 1500*x + 200*y, 4000 - 100*x - 1800*y
+	}
+	projective := func(lat, long float64) (float64, float64) {
+		x, y := long+74, lat-40.5
+		w := 1 + 0.2*x + 0.1*y
+		return (3000 + 1500*x + 200*y) / w, (4000 - 100*x - 1800*y) / w
+	}
+
+	latLongs := []LatLong{{41, -75}, {41, -73}, {40, -75}, {40, -73}, {40.5, -74}, {40.8, -73.6}}
+	check := LatLong{40.2, -74.7}
+
+	tests := []struct {
+		kind  string
+		truth func(lat, long float64) (float64, float64)
+	}{
+		{"affine", affine},
+		{"projective", affine},
+		{"projective", projective},
+	}
+
+	for _, test := range tests {
+		controlPoints := make([]ControlPoint, len(latLongs))
+		for i, latLong := range latLongs {
+			x, y := test.truth(latLong.Lat, latLong.Long)
+			controlPoints[i] = ControlPoint{ImageX: x, ImageY: y, LatLong: latLong}
+		}
+
+		registration, report, err := fitImageRegistration(controlPoints, test.kind, EquirectangularProjection{})
+		if err != nil {
+			t.Errorf("%s: %v", test.kind, err)
+			continue
+		}
+
+		if report.MaxResidualPx > 1e-6 {
+			t.Errorf("%s: max residual %v px for an exact fit", test.kind, report.MaxResidualPx)
+		}
+
+		wantX, wantY := test.truth(check.Lat, check.Long)
+		gotX, gotY := registration.Apply(check.Lat, check.Long)
+		if math.Hypot(gotX-wantX, gotY-wantY) > 1e-6 {
+			t.Errorf("%s: Apply(%v) = %v, %v, want %v, %v", test.kind, check, gotX, gotY, wantX, wantY)
+		}
+	}
+}
+
+func TestFitImageRegistrationErrors(t *testing.T) {
+	tests := []struct {
+		name          string
+		kind          string
+		controlPoints []ControlPoint
+	}{
+		{"unknown transform", "similarity", []ControlPoint{{0, 0, LatLong{40, -74}}, {1, 0, LatLong{40, -73}}, {0, 1, LatLong{41, -74}}}},
+		{"too few affine", "affine", []ControlPoint{{0, 0, LatLong{40, -74}}, {1, 0, LatLong{40, -73}}}},
+		{"too few projective", "projective", []ControlPoint{{0, 0, LatLong{40, -74}}, {1, 0, LatLong{40, -73}}, {0, 1, LatLong{41, -74}}}},
+		{"collinear", "affine", []ControlPoint{{0, 0, LatLong{40, -74}}, {1, 1, LatLong{40.5, -73.5}}, {2, 2, LatLong{41, -73}}}},
+		{"same lat/long", "affine", []ControlPoint{{0, 0, LatLong{40, -74}}, {1, 0, LatLong{40, -74}}, {0, 1, LatLong{40, -74}}}},
+	}
+
+	for _, test := range tests {
+		if _, _, err := fitImageRegistration(test.controlPoints, test.kind, EquirectangularProjection{}); err == nil {
+			t.Errorf("%s: expected an error", test.name)
+		}
+	}
+}
+
+func TestSolveLinearSystem(t *testing.T) {
+	tests := []struct {
+		name     string
+		m        [][]float64
+		v        []float64
+		want     []float64
+		singular bool
+	}{
+		{"needs pivoting", [][]float64{{0, 1}, {2, 0}}, []float64{3, 4}, []float64{2, 3}, false},
+		{"tiny units", [][]float64{{2e-14, 1e-14}, {1e-14, 3e-14}}, []float64{5e-14, 1e-13}, []float64{1, 3}, false},
+		{"huge units", [][]float64{{2e9, 1e9}, {1e9, 3e9}}, []float64{5e9, 1e10}, []float64{1, 3}, false},
+		{"singular", [][]float64{{1, 2}, {2, 4}}, []float64{3, 6}, nil, true},
+		{"zero", [][]float64{{0, 0}, {0, 0}}, []float64{0, 0}, nil, true},
+	}
+
+	for _, test := range tests {
+		got, err := solveLinearSystem(test.m, test.v)
+		if (err != nil) != test.singular {
+			t.Errorf("%s: error = %v, singular %v", test.name, err, test.singular)
+			continue
+		}
+
+		for i := range test.want {
+			if math.Abs(got[i]-test.want[i]) > 1e-9 {
+				t.Errorf("%s: x = %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSubmittedImageMapperBoxScalesEachAxis(t *testing.T) {
+	overlayLatLongBounds := OverlayBounds{TopLeft: LatLong{41, -75}, BottomRight: LatLong{40, -73}}
+	// a 100x50 overlay stretched into a 200x50 box, so x and y scales differ
+	placement := ImagePlacement{OverlayLocTopLeftX: 10, OverlayLocTopLeftY: 20, OverlayLocBottomRightX: 210, OverlayLocBottomRightY: 70}
+
+	mapper, _, err := newSubmittedImageMapper(placement, image.Rect(0, 0, 100, 50), overlayLatLongBounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ox, oy int
+		sx, sy int
+	}{
+		{0, 0, 10, 20},
+		{50, 25, 110, 45},
+		{99, 49, 208, 69},
+	}
+
+	for _, test := range tests {
+		sx, sy := mapper.SourcePixel(test.ox, test.oy)
+		if sx != test.sx || sy != test.sy {
+			t.Errorf("SourcePixel(%d, %d) = %d, %d, want %d, %d", test.ox, test.oy, sx, sy, test.sx, test.sy)
+		}
+	}
+}
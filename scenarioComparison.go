@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+const topDecileRank float64 = 0.9
+
+func resolveComparisonSide(side ComparisonSide) (AggregateDataRequest, error) {
+	if side.Request != nil {
+		return *side.Request, nil
+	}
+
+	if side.Scenario == "" {
+		return AggregateDataRequest{}, fmt.Errorf("each side of a comparison needs a scenario or a request")
+	}
+
+	scenario, err := loadScenario(side.Scenario)
+	return scenario.Request, err
+}
+
+// compareScenarios aggregates both sides on the same sampling grid and compares
+// them at the cells both aggregated, ranking cells within each side
+func compareScenarios(request CompareScenariosRequest) (ScenarioComparison, error) {
+	var comparison ScenarioComparison
+
+	requestA, err := resolveComparisonSide(request.A)
+	if err != nil {
+		return comparison, err
+	}
+
+	requestB, err := resolveComparisonSide(request.B)
+	if err != nil {
+		return comparison, err
+	}
+
+	if requestA.SamplingRate != requestB.SamplingRate {
+		return comparison, fmt.Errorf("sampling rates %d and %d differ, so the aggregations can't be compared cell by cell", requestA.SamplingRate, requestB.SamplingRate)
+	}
+
+	cellsA, err := scoreAggregationCells(requestA)
+	if err != nil {
+		return comparison, err
+	}
+
+	cellsB, err := scoreAggregationCells(requestB)
+	if err != nil {
+		return comparison, err
+	}
+
+	valuesA := cellsA.Combine(cellsA.Weights())
+	valuesB := cellsB.Combine(cellsB.Weights())
+
+	valueAByPosition := make(map[Position]float64)
+	for i, cell := range cellsA.positions {
+		valueAByPosition[cell] = valuesA[i]
+	}
+
+	commonCells := []Position{}
+	commonA, commonB := []float64{}, []float64{}
+	for i, cell := range cellsB.positions {
+		valueA, found := valueAByPosition[cell]
+		if !found {
+			continue
+		}
+
+		commonCells = append(commonCells, cell)
+		commonA = append(commonA, valueA)
+		commonB = append(commonB, valuesB[i])
+	}
+
+	comparison.GapX, comparison.GapY = cellsB.gapX, cellsB.gapY
+	comparison.DifferenceData = []LatLongValue{}
+	comparison.EnteredTopDecile = []LatLong{}
+	comparison.LeftTopDecile = []LatLong{}
+	if len(commonCells) == 0 {
+		return comparison, nil
+	}
+
+	ranksA, ranksB := rankPercentiles(commonA), rankPercentiles(commonB)
+
+	topA, topB, topBoth := 0, 0, 0
+	for i, cell := range commonCells {
+		lat, long := getLatLong(cell.X, cell.Y, cellsB.gapX, cellsB.gapY, cellsB.overlayLatLongBounds)
+
+		difference := commonB[i] - commonA[i]
+		comparison.DifferenceData = append(comparison.DifferenceData, LatLongValue{lat, long, difference})
+		comparison.MeanAbsDifference += math.Abs(difference) / float64(len(commonCells))
+
+		isTopA, isTopB := ranksA[i] >= topDecileRank, ranksB[i] >= topDecileRank
+		if isTopA {
+			topA++
+		}
+		if isTopB {
+			topB++
+		}
+
+		switch {
+		case isTopA && isTopB:
+			topBoth++
+		case isTopB:
+			comparison.EnteredTopDecile = append(comparison.EnteredTopDecile, LatLong{Lat: lat, Long: long})
+		case isTopA:
+			comparison.LeftTopDecile = append(comparison.LeftTopDecile, LatLong{Lat: lat, Long: long})
+		}
+	}
+
+	// overlap is measured against the union so it's symmetric between the sides
+	if topA+topB-topBoth > 0 {
+		comparison.TopDecileOverlap = float64(topBoth) / float64(topA+topB-topBoth)
+	}
+
+	comparison.RankCorrelation = pearsonCorrelation(ranksA, ranksB)
+
+	return comparison, nil
+}
+
+// pearsonCorrelation of ranks is the spearman rank correlation. Constant inputs
+// have no defined correlation and give 0.
+func pearsonCorrelation(a, b []float64) float64 {
+	meanA, meanB := 0.0, 0.0
+	for i := range a {
+		meanA += a[i] / float64(len(a))
+		meanB += b[i] / float64(len(b))
+	}
+
+	covariance, varianceA, varianceB := 0.0, 0.0, 0.0
+	for i := range a {
+		covariance += (a[i] - meanA) * (b[i] - meanB)
+		varianceA += (a[i] - meanA) * (a[i] - meanA)
+		varianceB += (b[i] - meanB) * (b[i] - meanB)
+	}
+
+	if varianceA == 0 || varianceB == 0 {
+		return 0
+	}
+
+	return covariance / math.Sqrt(varianceA*varianceB)
+}
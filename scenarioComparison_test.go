@@ -0,0 +1,116 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestPearsonCorrelation(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		{"identical", []float64{1, 2, 3}, []float64{1, 2, 3}, 1},
+		{"scaled and shifted", []float64{1, 2, 3}, []float64{10, 30, 50}, 1},
+		{"reversed", []float64{1, 2, 3}, []float64{3, 2, 1}, -1},
+		{"uncorrelated", []float64{1, 2, 3, 4}, []float64{1, -1, -1, 1}, 0},
+		{"constant", []float64{1, 2, 3}, []float64{5, 5, 5}, 0},
+		{"known value", []float64{1, 2, 3, 4, 5}, []float64{2, 4, 5, 4, 5}, 0.7745966692},
+	}
+
+	for _, test := range tests {
+		if got := pearsonCorrelation(test.a, test.b); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSpearmanCorrelation(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		// any monotonic relationship is a perfect rank correlation, unlike pearson
+		{"monotonic", []float64{1, 2, 3, 4}, []float64{1, 4, 9, 100}, 1},
+		{"reversed", []float64{1, 2, 3, 4}, []float64{0.9, 0.5, 0.2, 0.1}, -1},
+		// without ties this matches 1 - 6*sum(d^2)/(n(n^2-1))
+		{"one swap", []float64{1, 2, 3, 4, 5}, []float64{1, 3, 2, 4, 5}, 0.9},
+		{"ties", []float64{1, 2, 2, 3}, []float64{1, 2, 3, 4}, 0.9486832981},
+	}
+
+	for _, test := range tests {
+		if got := pearsonCorrelation(rankPercentiles(test.a), rankPercentiles(test.b)); math.Abs(got-test.want) > 1e-9 {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCompareScenarios(t *testing.T) {
+	chdirTemp(t)
+
+	// ten cells rising from west to east, so each side's top decile is a single cell
+	writeOverlayFixture(t, 10, 1, func(x, y int) bool { return true })
+	writeMapFixture(t, "compareA", 10, 1, func(x, y int) color.NRGBA { return valuePixel(uint8(x * 25)) })
+
+	side := func(isHighGood bool, samplingRate int) ComparisonSide {
+		return ComparisonSide{Request: &AggregateDataRequest{
+			Tags:         []AggregateDataTagInfo{{Tag: "compareA", IsHighGood: isHighGood, Weight: 1}},
+			SamplingRate: samplingRate,
+		}}
+	}
+
+	tests := []struct {
+		name            string
+		a, b            ComparisonSide
+		wantCorrelation float64
+		wantOverlap     float64
+		wantEntered     []LatLong
+		wantLeft        []LatLong
+		wantErr         string
+	}{
+		{"same request", side(true, 1), side(true, 1), 1, 1, nil, nil, ""},
+		{"reversed", side(true, 1), side(false, 1), -1, 0, []LatLong{{Lat: 1, Long: 0}}, []LatLong{{Lat: 1, Long: 9}}, ""},
+		{"sampling rate of 0", side(true, 0), side(true, 0), 0, 0, nil, nil, "sampling rate"},
+		{"different sampling rates", side(true, 1), side(true, 2), 0, 0, nil, nil, "differ"},
+		{"empty side", side(true, 1), ComparisonSide{}, 0, 0, nil, nil, "needs a scenario or a request"},
+	}
+
+	for _, test := range tests {
+		comparison, err := compareScenarios(CompareScenariosRequest{A: test.a, B: test.b})
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error = %v, want one containing %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if math.Abs(comparison.RankCorrelation-test.wantCorrelation) > 1e-9 || comparison.TopDecileOverlap != test.wantOverlap {
+			t.Errorf("%s: correlation %v, overlap %v, want %v, %v", test.name, comparison.RankCorrelation, comparison.TopDecileOverlap, test.wantCorrelation, test.wantOverlap)
+		}
+		if !sameLatLongs(comparison.EnteredTopDecile, test.wantEntered) || !sameLatLongs(comparison.LeftTopDecile, test.wantLeft) {
+			t.Errorf("%s: entered %v, left %v, want %v, %v", test.name, comparison.EnteredTopDecile, comparison.LeftTopDecile, test.wantEntered, test.wantLeft)
+		}
+	}
+}
+
+func sameLatLongs(a, b []LatLong) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if math.Abs(a[i].Lat-b[i].Lat) > 1e-9 || math.Abs(a[i].Long-b[i].Long) > 1e-9 {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+const scenariosDir = "./database/scenarios"
+
+// scenario names become file names, so they're kept to characters safe in a path
+var scenarioNameRegex = regexp.MustCompile(`^[A-Za-z0-9 _-]{1,100}$`)
+
+func scenarioPath(name string) (string, error) {
+	if !scenarioNameRegex.MatchString(name) {
+		return "", fmt.Errorf("scenario name %q must be 1 to 100 letters, digits, spaces, underscores or dashes", name)
+	}
+
+	return filepath.Join(scenariosDir, name+".json"), nil
+}
+
+// saveScenario stores the aggregation request under the scenario's name, replacing any scenario already saved under it
+func saveScenario(scenario Scenario) (Scenario, error) {
+	scenarioFilePath, err := scenarioPath(scenario.Name)
+	if err != nil {
+		return scenario, err
+	}
+
+	// validated up front so a scenario that can never run isn't saved
+	if err := normalizeAggregateDataRequest(&scenario.Request); err != nil {
+		return scenario, err
+	}
+
+	scenario.SavedAt = time.Now()
+
+	scenarioJson, err := json.MarshalIndent(scenario, "", "  ")
+	if err != nil {
+		return scenario, err
+	}
+
+	if err := os.MkdirAll(scenariosDir, 0o755); err != nil {
+		return scenario, fmt.Errorf("error creating scenarios directory: %w", err)
+	}
+
+	if err := os.WriteFile(scenarioFilePath, scenarioJson, 0o644); err != nil {
+		return scenario, fmt.Errorf("error writing scenario: %w", err)
+	}
+
+	return scenario, nil
+}
+
+func loadScenario(name string) (Scenario, error) {
+	var scenario Scenario
+
+	scenarioFilePath, err := scenarioPath(name)
+	if err != nil {
+		return scenario, err
+	}
+
+	scenarioJson, err := os.ReadFile(scenarioFilePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return scenario, fmt.Errorf("no scenario named %s", name)
+	} else if err != nil {
+		return scenario, err
+	}
+
+	if err := json.Unmarshal(scenarioJson, &scenario); err != nil {
+		return scenario, fmt.Errorf("failed to parse scenario %s: %w", name, err)
+	}
+
+	return scenario, nil
+}
+
+// listScenarios gives every saved scenario, most recently saved first
+func listScenarios() ([]Scenario, error) {
+	scenarios := []Scenario{}
+
+	dirents, err := os.ReadDir(scenariosDir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return scenarios, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	for _, dirent := range dirents {
+		if dirent.IsDir() || !strings.HasSuffix(dirent.Name(), ".json") {
+			continue
+		}
+
+		scenario, err := loadScenario(stripExtension(dirent.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		scenarios = append(scenarios, scenario)
+	}
+
+	slices.SortFunc(scenarios, func(a, b Scenario) int { return b.SavedAt.Compare(a.SavedAt) })
+
+	return scenarios, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScenarioPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		isValid bool
+	}{
+		{"coastal sites", true},
+		{"Plan_B-2", true},
+		{strings.Repeat("a", 100), true},
+		{"", false},
+		{strings.Repeat("a", 101), false},
+		{"../maps/a", false},
+		{"sub/dir", false},
+		{`back\slash`, false},
+		{"dotted.name", false},
+		{"café", false},
+	}
+
+	for _, test := range tests {
+		_, err := scenarioPath(test.name)
+		if isValid := err == nil; isValid != test.isValid {
+			t.Errorf("%q: valid = %v, want %v (%v)", test.name, isValid, test.isValid, err)
+		}
+	}
+}
+
+func TestScenarioRoundTrip(t *testing.T) {
+	chdirTemp(t)
+
+	scenarios, err := listScenarios()
+	if err != nil || len(scenarios) != 0 {
+		t.Fatalf("expected no scenarios before any are saved, got %v, %v", scenarios, err)
+	}
+
+	first := Scenario{
+		Name: "first",
+		Request: AggregateDataRequest{
+			Tags:         []AggregateDataTagInfo{{Tag: "a", IsHighGood: true, Weight: 2, MinScore: ptr(0.25)}},
+			SamplingRate: 3,
+			NoDataPolicy: "neutral",
+		},
+	}
+	savedFirst, err := saveScenario(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// saving normalizes the request, filling in its defaults
+	if savedFirst.Request.ConstraintMode != "exclude" || savedFirst.SavedAt.IsZero() {
+		t.Errorf("saved scenario %+v, want its defaults filled and save time set", savedFirst)
+	}
+
+	loaded, err := loadScenario("first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Request, savedFirst.Request) || !loaded.SavedAt.Equal(savedFirst.SavedAt) {
+		t.Errorf("loaded %+v, want %+v", loaded, savedFirst)
+	}
+
+	second := Scenario{Name: "second", Request: AggregateDataRequest{SamplingRate: 1}}
+	if _, err := saveScenario(second); err != nil {
+		t.Fatal(err)
+	}
+
+	// resaving replaces the scenario, moving it to the front
+	first.Request.SamplingRate = 5
+	if _, err := saveScenario(first); err != nil {
+		t.Fatal(err)
+	}
+
+	scenarios, err = listScenarios()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, scenario := range scenarios {
+		names = append(names, scenario.Name)
+	}
+	if strings.Join(names, ",") != "first,second" || scenarios[0].Request.SamplingRate != 5 {
+		t.Errorf("listed %+v, want first resaved with sampling rate 5, then second", scenarios)
+	}
+}
+
+func TestScenarioErrors(t *testing.T) {
+	chdirTemp(t)
+
+	if _, err := saveScenario(Scenario{Name: "../escape", Request: AggregateDataRequest{SamplingRate: 1}}); err == nil {
+		t.Errorf("expected an unsafe name to be rejected")
+	}
+
+	if _, err := saveScenario(Scenario{Name: "invalid", Request: AggregateDataRequest{SamplingRate: 1, NoDataPolicy: "ignore"}}); err == nil {
+		t.Errorf("expected a request that can never run to be rejected")
+	}
+	if _, err := os.Stat(scenariosDir + "/invalid.json"); err == nil {
+		t.Errorf("expected a rejected scenario not to be saved")
+	}
+
+	if _, err := loadScenario("missing"); err == nil || !strings.Contains(err.Error(), "no scenario named missing") {
+		t.Errorf("error = %v, want the missing scenario reported", err)
+	}
+}
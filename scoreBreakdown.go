@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"os"
+)
+
+// scoreLocation explains how the aggregation request scores a single location,
+// averaging each tag's stored map over the sampled cell holding it rather than aggregating the whole overlay
+func scoreLocation(latLong LatLong, request AggregateDataRequest) (ScoreBreakdown, error) {
+	breakdown := ScoreBreakdown{LatLong: latLong, Tags: []TagScoreBreakdown{}}
+
+	if err := normalizeAggregateDataRequest(&request); err != nil {
+		return breakdown, err
+	}
+
+	if request.Operator == "topsis" {
+		return breakdown, fmt.Errorf("topsis scores relative to every other location so can't score a single one")
+	}
+
+	overlayImg, overlayLatLongBounds, err := getOverlayData()
+	if err != nil {
+		return breakdown, err
+	}
+
+	overlayBounds := overlayImg.Bounds()
+	gapX, gapY := getOverlayLatLongGaps(overlayBounds.Max.X, overlayBounds.Max.Y, overlayLatLongBounds)
+
+	x, y := getPixel(latLong.Lat, latLong.Long, gapX, gapY, overlayLatLongBounds)
+	if !(image.Point{X: x, Y: y}).In(overlayBounds) || !isWithinOverlay(overlayImg, x, y) {
+		return breakdown, fmt.Errorf("%f, %f is outside the overlay", latLong.Lat, latLong.Long)
+	}
+
+	// the same cell computeFileValues averages, so the total matches the aggregation
+	cellX, cellY := x/request.SamplingRate*request.SamplingRate, y/request.SamplingRate*request.SamplingRate
+
+	validTags, err := filterTags(request.Tags)
+	if err != nil {
+		return breakdown, err
+	}
+
+	owaWeights, err := owaRankWeights(request, len(validTags))
+	if err != nil {
+		return breakdown, err
+	}
+
+	totalWeight := 0.0
+	for _, tagInfo := range validTags {
+		totalWeight += tagInfo.Weight
+	}
+
+	// each tag is read as a single cell map, so scoring goes through the same steps as a full aggregation
+	allResults := []TaggedImageData{}
+	for _, tagInfo := range validTags {
+		rawValue, noData, datasetInfo, err := readStoredMapCell(tagInfo.Tag, cellX, cellY, request.SamplingRate, overlayImg)
+		if err != nil {
+			return breakdown, err
+		}
+
+		score := rawValue
+		if !tagInfo.IsHighGood {
+			score = 1 - rawValue
+		}
+
+		result := weightFileValues(tagInfo, [][]float64{{score}}, [][]bool{{noData}}, tagInfo.Weight/totalWeight, request.NoDataPolicy)
+		allResults = append(allResults, result)
+
+		breakdown.Tags = append(breakdown.Tags, TagScoreBreakdown{
+			Tag:             tagInfo.Tag,
+			RawValue:        rawValue,
+			NoData:          noData,
+			Score:           result.scores[0][0],
+			Weight:          result.weight,
+			MeetsConstraint: meetsConstraints([]TaggedImageData{result}, 0, 0, request.NoDataPolicy),
+			Dataset:         datasetInfo,
+		})
+	}
+
+	scores, isKnown := cellScores(allResults, 0, 0, request.NoDataPolicy)
+	breakdown.IsKnown = isKnown
+	breakdown.MeetsConstraints = meetsConstraints(allResults, 0, 0, request.NoDataPolicy)
+	if !isKnown {
+		return breakdown, nil
+	}
+
+	weights := make([]float64, len(allResults))
+	for i, result := range allResults {
+		weights[i] = result.weight
+	}
+
+	breakdown.Total = newCellAggregator(request.Operator, owaWeights, weights, [][]cellScore{scores}).Combine(scores)
+
+	if request.Operator == "" || request.Operator == "arithmeticMean" {
+		presentWeight := 0.0
+		for _, s := range scores {
+			presentWeight += weights[s.tagI]
+		}
+
+		for _, s := range scores {
+			contribution := 0.0
+			if presentWeight > 0 {
+				contribution = weights[s.tagI] * s.score / presentWeight
+			}
+			breakdown.Tags[s.tagI].Contribution = &contribution
+		}
+	}
+
+	return breakdown, nil
+}
+
+// readStoredMapCell averages the in-overlay pixels of the sampled cell with its top left at x, y,
+// reporting no data when every one of them is no data
+func readStoredMapCell(tag string, x, y, samplingRate int, overlayImg image.Image) (float64, bool, DatasetInfo, error) {
+	var datasetInfo DatasetInfo
+
+	filename := "./database/maps/" + tag + ".png"
+	stat, err := os.Stat(filename)
+	if err != nil {
+		return 0, false, datasetInfo, err
+	}
+	datasetInfo.UpdatedAt = stat.ModTime()
+
+	img, err := getStoredMap(tag)
+	if err != nil {
+		return 0, false, datasetInfo, err
+	}
+
+	bounds := img.Bounds()
+	datasetInfo.Width, datasetInfo.Height = bounds.Max.X, bounds.Max.Y
+	// computeFileValues only samples whole cells, so a partial cell at the edge is never scored
+	if x+samplingRate > bounds.Max.X || y+samplingRate > bounds.Max.Y {
+		return 0, false, datasetInfo, fmt.Errorf("map %s doesn't cover the sampled cell at %d, %d", tag, x, y)
+	}
+
+	sumValue := 0
+	numRelevant := 0
+	numNoData := 0
+	for offY := range samplingRate {
+		for offX := range samplingRate {
+			if !isWithinOverlay(overlayImg, x+offX, y+offY) {
+				continue
+			}
+
+			r, g, b, a := getRgba(img, x+offX, y+offY)
+			if isNoDataPixel(r, g, b, a) {
+				numNoData++
+				continue
+			}
+
+			sumValue += int(g)
+			numRelevant++
+		}
+	}
+
+	if numRelevant == 0 {
+		return 0, numNoData > 0, datasetInfo, nil
+	}
+
+	return float64(sumValue) / float64(numRelevant) / 255, false, datasetInfo, nil
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestScoreLocation(t *testing.T) {
+	chdirTemp(t)
+
+	// 4x4 overlay missing only the pixel 1, 1
+	writeOverlayFixture(t, 4, 4, func(x, y int) bool { return x != 1 || y != 1 })
+
+	// the top left cell at a sampling rate of 2 holds 100, 200, no data and a value outside the overlay,
+	// and the bottom right cell is all no data
+	writeMapFixture(t, "scoreA", 4, 4, func(x, y int) color.NRGBA {
+		switch {
+		case x >= 2 && y >= 2:
+			return noDataPixel
+		case x == 0 && y == 0:
+			return valuePixel(100)
+		case x == 1 && y == 0:
+			return valuePixel(200)
+		case x == 0 && y == 1:
+			return noDataPixel
+		default:
+			return valuePixel(255)
+		}
+	})
+
+	tests := []struct {
+		name         string
+		latLong      LatLong
+		samplingRate int
+		isHighGood   bool
+		wantRaw      float64
+		wantNoData   bool
+		wantTotal    float64
+		wantErr      string
+	}{
+		{"averages the in-overlay data of the sampled cell", LatLong{Lat: 3.5, Long: 1.5}, 2, true, 150.0 / 255, false, 150.0 / 255, ""},
+		{"any pixel of the cell gives the same average", LatLong{Lat: 2.5, Long: 0.5}, 2, true, 150.0 / 255, false, 150.0 / 255, ""},
+		{"a sampling rate of 1 reads the pixel", LatLong{Lat: 3.5, Long: 1.5}, 1, true, 200.0 / 255, false, 200.0 / 255, ""},
+		{"low is good inverts the score", LatLong{Lat: 3.5, Long: 1.5}, 2, false, 150.0 / 255, false, 1 - 150.0/255, ""},
+		{"a cell of no data scores worst", LatLong{Lat: 0.5, Long: 3.5}, 2, true, 0, true, 0, ""},
+		{"a partial cell at the edge isn't sampled", LatLong{Lat: 0.5, Long: 3.5}, 3, true, 0, false, 0, "doesn't cover the sampled cell"},
+		{"outside the overlay", LatLong{Lat: 2.5, Long: 1.5}, 1, true, 0, false, 0, "outside the overlay"},
+		{"sampling rate of 0", LatLong{Lat: 3.5, Long: 1.5}, 0, true, 0, false, 0, "sampling rate"},
+	}
+
+	for _, test := range tests {
+		request := AggregateDataRequest{
+			Tags:         []AggregateDataTagInfo{{Tag: "scoreA", IsHighGood: test.isHighGood, Weight: 1}, {Tag: "missing", Weight: 1}},
+			SamplingRate: test.samplingRate,
+		}
+
+		breakdown, err := scoreLocation(test.latLong, request)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("%s: error = %v, want one containing %q", test.name, err, test.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		if len(breakdown.Tags) != 1 {
+			t.Errorf("%s: got %d tags, want only the stored one", test.name, len(breakdown.Tags))
+			continue
+		}
+
+		tag := breakdown.Tags[0]
+		if math.Abs(tag.RawValue-test.wantRaw) > 1e-9 || tag.NoData != test.wantNoData {
+			t.Errorf("%s: raw value %v, no data %v, want %v, %v", test.name, tag.RawValue, tag.NoData, test.wantRaw, test.wantNoData)
+		}
+		if math.Abs(breakdown.Total-test.wantTotal) > 1e-9 {
+			t.Errorf("%s: total %v, want %v", test.name, breakdown.Total, test.wantTotal)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+)
+
+const (
+	defaultSweepSteps        = 5
+	defaultMonteCarloSamples = 200
+	maxMonteCarloSamples     = 1000
+	defaultTopPercent        = 10
+	defaultSensitivityAreas  = 5
+)
+
+// analyzeSensitivity reaggregates under perturbed weights. Weights are sampled
+// uniformly from their ranges to measure how stable each cell's rank is, and
+// swept one tag at a time to find which weight each top area depends on most.
+func analyzeSensitivity(request SensitivityAnalysisRequest) (SensitivityAnalysisResponse, error) {
+	var response SensitivityAnalysisResponse
+
+	sweepSteps := request.SweepSteps
+	if sweepSteps == 0 {
+		sweepSteps = defaultSweepSteps
+	}
+	if sweepSteps < 2 {
+		return response, fmt.Errorf("sweep needs at least 2 steps")
+	}
+
+	numSamples := request.MonteCarloSamples
+	if numSamples == 0 {
+		numSamples = defaultMonteCarloSamples
+	}
+	if numSamples < 1 || numSamples > maxMonteCarloSamples {
+		return response, fmt.Errorf("monte carlo samples must be between 1 and %d", maxMonteCarloSamples)
+	}
+
+	topPercent := request.TopPercent
+	if topPercent == 0 {
+		topPercent = defaultTopPercent
+	}
+	if topPercent <= 0 || topPercent > 100 {
+		return response, fmt.Errorf("top percent %f must be between 0 and 100", topPercent)
+	}
+
+	if request.TopN == 0 {
+		request.TopN = defaultSensitivityAreas
+	}
+
+	cells, err := scoreAggregationCells(request.AggregateDataRequest)
+	if err != nil {
+		return response, err
+	}
+
+	// sampled weights are raw like the request's, and normalized before combining
+	baseWeights := make([]float64, len(cells.allResults))
+	tagIByTag := make(map[string]int)
+	for i, result := range cells.allResults {
+		tagIByTag[result.Tag] = i
+		tagInfo, _ := Find(cells.request.Tags, func(tag AggregateDataTagInfo) bool { return tag.Tag == result.Tag })
+		baseWeights[i] = tagInfo.Weight
+	}
+
+	for _, weightRange := range request.WeightRanges {
+		if _, found := tagIByTag[weightRange.Tag]; !found {
+			return response, fmt.Errorf("weight range given for tag %s which isn't being aggregated", weightRange.Tag)
+		}
+		if weightRange.MinWeight < 0 || weightRange.MaxWeight < weightRange.MinWeight {
+			return response, fmt.Errorf("weight range %f to %f of tag %s must be non-negative and increasing", weightRange.MinWeight, weightRange.MaxWeight, weightRange.Tag)
+		}
+	}
+
+	rankUnder := func(rawWeights []float64) ([]float64, []float64) {
+		values := cells.Combine(normalizeWeights(rawWeights))
+		return values, rankPercentiles(values)
+	}
+
+	baseValues, baseRanks := rankUnder(baseWeights)
+
+	response.GapX, response.GapY = cells.gapX, cells.gapY
+	response.Cells = make([]CellSensitivity, len(cells.positions))
+	for i, cell := range cells.positions {
+		lat, long := getLatLong(cell.X, cell.Y, cells.gapX, cells.gapY, cells.overlayLatLongBounds)
+		response.Cells[i] = CellSensitivity{
+			LatLong:   LatLong{Lat: lat, Long: long},
+			BaseValue: baseValues[i],
+			BaseRank:  baseRanks[i],
+			MinRank:   math.Inf(1),
+			MaxRank:   math.Inf(-1),
+		}
+	}
+
+	rng := rand.New(rand.NewSource(1))
+	rankSums := make([]float64, len(cells.positions))
+	rankSquareSums := make([]float64, len(cells.positions))
+	topCounts := make([]int, len(cells.positions))
+	for range numSamples {
+		sampledWeights := slices.Clone(baseWeights)
+		for _, weightRange := range request.WeightRanges {
+			sampledWeights[tagIByTag[weightRange.Tag]] = weightRange.MinWeight + rng.Float64()*(weightRange.MaxWeight-weightRange.MinWeight)
+		}
+
+		_, ranks := rankUnder(sampledWeights)
+		for i, rank := range ranks {
+			rankSums[i] += rank
+			rankSquareSums[i] += rank * rank
+			response.Cells[i].MinRank = math.Min(response.Cells[i].MinRank, rank)
+			response.Cells[i].MaxRank = math.Max(response.Cells[i].MaxRank, rank)
+			if rank >= 1-topPercent/100 {
+				topCounts[i]++
+			}
+		}
+	}
+
+	for i := range response.Cells {
+		mean := rankSums[i] / float64(numSamples)
+		response.Cells[i].MeanRank = mean
+		response.Cells[i].RankStdDev = math.Sqrt(math.Max(0, rankSquareSums[i]/float64(numSamples)-mean*mean))
+		response.Cells[i].TopProbability = float64(topCounts[i]) / float64(numSamples)
+	}
+
+	areaCellGroups, err := findCandidateAreaCells(cells.request, cells.CandidateCells(baseValues), cells.gapX, cells.gapY, cells.overlayLatLongBounds)
+	if err != nil {
+		return response, err
+	}
+
+	cellIByPosition := make(map[Position]int)
+	for i, cell := range cells.positions {
+		cellIByPosition[cell] = i
+	}
+
+	areaRank := func(areaCells []PositionValue, ranks []float64) float64 {
+		sum := 0.0
+		for _, cell := range areaCells {
+			sum += ranks[cellIByPosition[cell.Position]]
+		}
+
+		return sum / float64(len(areaCells))
+	}
+
+	response.Areas = []AreaSensitivity{}
+	for _, areaCells := range areaCellGroups {
+		response.Areas = append(response.Areas, AreaSensitivity{
+			Area:           newCandidateArea(areaCells, cells.allResults, cells.gapX, cells.gapY, cells.overlayLatLongBounds),
+			RankSwingByTag: map[string]float64{},
+		})
+	}
+
+	for _, weightRange := range request.WeightRanges {
+		minAreaRanks := make([]float64, len(areaCellGroups))
+		maxAreaRanks := make([]float64, len(areaCellGroups))
+		for i := range areaCellGroups {
+			minAreaRanks[i], maxAreaRanks[i] = math.Inf(1), math.Inf(-1)
+		}
+
+		for step := range sweepSteps {
+			sweptWeights := slices.Clone(baseWeights)
+			sweptWeights[tagIByTag[weightRange.Tag]] = weightRange.MinWeight + float64(step)/float64(sweepSteps-1)*(weightRange.MaxWeight-weightRange.MinWeight)
+
+			_, ranks := rankUnder(sweptWeights)
+			for i, areaCells := range areaCellGroups {
+				rank := areaRank(areaCells, ranks)
+				minAreaRanks[i] = math.Min(minAreaRanks[i], rank)
+				maxAreaRanks[i] = math.Max(maxAreaRanks[i], rank)
+			}
+		}
+
+		for i := range response.Areas {
+			swing := maxAreaRanks[i] - minAreaRanks[i]
+			response.Areas[i].RankSwingByTag[weightRange.Tag] = swing
+
+			mostSensitiveTag := response.Areas[i].MostSensitiveTag
+			if mostSensitiveTag == "" || swing > response.Areas[i].RankSwingByTag[mostSensitiveTag] {
+				response.Areas[i].MostSensitiveTag = weightRange.Tag
+			}
+		}
+	}
+
+	return response, nil
+}
+
+func normalizeWeights(weights []float64) []float64 {
+	total := 0.0
+	for _, weight := range weights {
+		total += weight
+	}
+
+	normalized := make([]float64, len(weights))
+	if total == 0 {
+		return normalized
+	}
+
+	for i, weight := range weights {
+		normalized[i] = weight / total
+	}
+
+	return normalized
+}
+
+// rankPercentiles gives the fraction of the other values below each value, with
+// ties sharing the middle of the ranks they span so a flat map doesn't rank bottom
+func rankPercentiles(values []float64) []float64 {
+	ranks := make([]float64, len(values))
+	if len(values) < 2 {
+		for i := range ranks {
+			ranks[i] = 1
+		}
+
+		return ranks
+	}
+
+	order := make([]int, len(values))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int { return -compareDesc(values[a], values[b]) })
+
+	for i := 0; i < len(order); {
+		j := i
+		for j < len(order) && values[order[j]] == values[order[i]] {
+			j++
+		}
+
+		for _, k := range order[i:j] {
+			ranks[k] = (float64(i) + float64(j-1-i)/2) / float64(len(values)-1)
+		}
+		i = j
+	}
+
+	return ranks
+}
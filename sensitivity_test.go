@@ -0,0 +1,58 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestRankPercentiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   []float64
+	}{
+		{"empty", []float64{}, []float64{}},
+		{"single value ranks top", []float64{0.3}, []float64{1}},
+		{"ascending", []float64{1, 2, 3}, []float64{0, 0.5, 1}},
+		{"unordered", []float64{0.9, 0.1, 0.5, 0.3}, []float64{1, 0, 2.0 / 3, 1.0 / 3}},
+		{"ties share the middle of their ranks", []float64{1, 2, 2, 3}, []float64{0, 0.5, 0.5, 1}},
+		{"flat map ranks in the middle", []float64{4, 4, 4}, []float64{0.5, 0.5, 0.5}},
+	}
+
+	for _, test := range tests {
+		got := rankPercentiles(test.values)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+			continue
+		}
+
+		for i := range got {
+			if math.Abs(got[i]-test.want[i]) > 1e-9 {
+				t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestNormalizeWeights(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights []float64
+		want    []float64
+	}{
+		{"sums to 1", []float64{1, 3}, []float64{0.25, 0.75}},
+		{"already normalized", []float64{0.5, 0.5}, []float64{0.5, 0.5}},
+		{"all zero stays zero", []float64{0, 0}, []float64{0, 0}},
+	}
+
+	for _, test := range tests {
+		got := normalizeWeights(test.weights)
+		for i := range got {
+			if math.Abs(got[i]-test.want[i]) > 1e-9 {
+				t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
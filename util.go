@@ -2,20 +2,19 @@ package main
 
 import (
 	"image"
+	"image/draw"
 	"math"
 	"path"
 	"strings"
 )
 
+// decodeToRGBA copies the image into RGBA through draw, which converts common
+// image types a row at a time rather than a pixel at a time through At and Set
 func decodeToRGBA(img image.Image) *image.RGBA {
 	bounds := img.Bounds()
 	rgba := image.NewRGBA(bounds)
 
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			rgba.Set(x, y, img.At(x, y))
-		}
-	}
+	draw.Draw(rgba, bounds, img, bounds.Min, draw.Src)
 	return rgba
 }
 
@@ -24,14 +23,6 @@ func getRgba(img *image.RGBA, x, y int) (uint8, uint8, uint8, uint8) {
 	return img.Pix[off], img.Pix[off+1], img.Pix[off+2], img.Pix[off+3]
 }
 
-func colorDiff(x, y [4]uint8) (int, int, int, int) {
-	dr := Abs(int(x[0]) - int(y[0]))
-	dg := Abs(int(x[1]) - int(y[1]))
-	db := Abs(int(x[2]) - int(y[2]))
-	da := Abs(int(x[3]) - int(y[3]))
-	return dr, dg, db, da
-}
-
 func Abs(x int) int {
 	if x < 0 {
 		return -x
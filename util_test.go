@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"testing"
+)
+
+// chdirTemp runs the test inside a fresh directory, since the handlers read and write relative paths like ./database
+func chdirTemp(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		os.Chdir(wd)
+	})
+
+	return dir
+}
+
+func ptr[T any](val T) *T {
+	return &val
+}
+
+func writePngFixture(t *testing.T, filename string, width, height int, pixel func(x, y int) color.NRGBA) {
+	t.Helper()
+
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.SetNRGBA(x, y, pixel(x, y))
+		}
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeOverlayFixture writes an overlay of one degree pixels with its bottom left at 0, 0,
+// so the pixel x, y is centered on the lat/long height-y-0.5, x+0.5
+func writeOverlayFixture(t *testing.T, width, height int, inside func(x, y int) bool) {
+	t.Helper()
+
+	for _, dir := range []string{"./assets", "./database/maps"} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writePngFixture(t, "./assets/blackwhite.png", width, height, func(x, y int) color.NRGBA {
+		if inside(x, y) {
+			return color.NRGBA{A: 255}
+		}
+		return color.NRGBA{}
+	})
+
+	bounds := OverlayBounds{
+		TopLeft:     LatLong{Lat: float64(height), Long: 0},
+		BottomRight: LatLong{Lat: 0, Long: float64(width)},
+	}
+	boundsJson, err := json.Marshal(bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("./database/overlayData.json", boundsJson, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	invalidateOverlay()
+	t.Cleanup(invalidateOverlay)
+}
+
+// writeMapFixture stores a map for the tag, with the value of each pixel in the green channel
+func writeMapFixture(t *testing.T, tag string, width, height int, pixel func(x, y int) color.NRGBA) {
+	t.Helper()
+
+	writePngFixture(t, "./database/maps/"+tag+".png", width, height, pixel)
+
+	invalidateStoredMap(tag)
+	t.Cleanup(func() { invalidateStoredMap(tag) })
+}
+
+func valuePixel(g uint8) color.NRGBA {
+	return color.NRGBA{G: g, A: 255}
+}
+
+var noDataPixel = color.NRGBA{R: 255, A: 255}
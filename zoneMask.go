@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"io"
+	"sync"
+
+	"github.com/paulmach/orb"
+	"github.com/paulmach/orb/geojson"
+	"github.com/paulmach/orb/planar"
+)
+
+type ZoneFeature struct {
+	Geometry orb.Geometry
+	Score    float64
+}
+
+func submitZoneMask(geoJsonFile io.Reader, data SubmitZoneMaskData) (*image.RGBA, error) {
+	geoJsonBytes, err := io.ReadAll(geoJsonFile)
+	if err != nil {
+		return nil, err
+	}
+
+	fc, err := geojson.UnmarshalFeatureCollection(geoJsonBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	zones, err := getZoneFeatures(fc, data)
+	if err != nil {
+		return nil, err
+	}
+
+	overlayMapImg, overlayLatLongBounds, err := getOverlayData()
+	if err != nil {
+		return nil, err
+	}
+
+	overlayBounds := overlayMapImg.Bounds()
+
+	gapX, gapY := getOverlayLatLongGaps(overlayBounds.Max.X, overlayBounds.Max.Y, overlayLatLongBounds)
+
+	colorDataMatrix := initDataMatrix[ColorValue](overlayBounds)
+
+	var wg sync.WaitGroup
+	for y := range overlayBounds.Max.Y {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for x := range overlayBounds.Max.X {
+				if !isWithinOverlay(overlayMapImg, x, y) {
+					colorDataMatrix[y][x] = ColorValue{IsWithinOverlay: false}
+					continue
+				}
+
+				lat, long := getLatLong(x, y, gapX, gapY, overlayLatLongBounds)
+				point := orb.Point{long, lat}
+
+				newColor := ColorValue{IsWithinOverlay: true, IsValueFound: false}
+				if data.DefaultScore != nil {
+					newColor = ColorValue{Value: *data.DefaultScore, IsWithinOverlay: true, IsValueFound: true}
+				}
+
+				// features listed first take precedence where zones overlap
+				for _, zone := range zones {
+					if geometryContains(zone.Geometry, point) {
+						newColor = ColorValue{Value: zone.Score, IsWithinOverlay: true, IsValueFound: true}
+						break
+					}
+				}
+
+				colorDataMatrix[y][x] = newColor
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	newImg := colorDataMatrixToImage(colorDataMatrix, overlayBounds)
+
+	return newImg, nil
+}
+
+func getZoneFeatures(fc *geojson.FeatureCollection, data SubmitZoneMaskData) ([]ZoneFeature, error) {
+	for category, score := range data.CategoryScores {
+		if score < 0 || score > 1 {
+			return nil, fmt.Errorf("score %f for category %s must be between 0 and 1", score, category)
+		}
+	}
+
+	if data.DefaultScore != nil && (*data.DefaultScore < 0 || *data.DefaultScore > 1) {
+		return nil, fmt.Errorf("default score %f must be between 0 and 1", *data.DefaultScore)
+	}
+
+	zones := []ZoneFeature{}
+	for _, feature := range fc.Features {
+		categoryObj, found := feature.Properties[data.CategoryProperty]
+		if !found {
+			return nil, fmt.Errorf("could not find category property %s for feature", data.CategoryProperty)
+		}
+
+		geoJsonType := feature.Geometry.GeoJSONType()
+		if geoJsonType != "MultiPolygon" && geoJsonType != "Polygon" {
+			return nil, fmt.Errorf("geometry not Polygon nor MultiPolygon")
+		}
+
+		// categories may be numeric codes (e.g. flood zone classes) so compare on their string form
+		category := fmt.Sprint(categoryObj)
+		score, found := data.CategoryScores[category]
+		if !found {
+			continue
+		}
+
+		zones = append(zones, ZoneFeature{Geometry: feature.Geometry, Score: score})
+	}
+
+	return zones, nil
+}
+
+func geometryContains(geometry orb.Geometry, point orb.Point) bool {
+	switch g := geometry.(type) {
+	case orb.Polygon:
+		return planar.PolygonContains(g, point)
+	case orb.MultiPolygon:
+		return planar.MultiPolygonContains(g, point)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestSubmitZoneMask(t *testing.T) {
+	chdirTemp(t)
+
+	// 4x2 overlay without its bottom right pixel, each pixel sampled at its top left corner
+	writeOverlayFixture(t, 4, 2, func(x, y int) bool { return x != 3 || y != 1 })
+
+	square := func(west, south, east, north string) string {
+		return `{"type": "Polygon", "coordinates": [[[` + west + `, ` + south + `], [` + east + `, ` + south + `], [` + east + `, ` + north + `], [` + west + `, ` + north + `], [` + west + `, ` + south + `]]]}`
+	}
+	feature := func(category, geometry string) string {
+		return `{"type": "Feature", "properties": {"zone": ` + category + `}, "geometry": ` + geometry + `}`
+	}
+	// zone A covers the middle two columns and overlaps zone 7 on the left, an unscored zone covers everything
+	geoJson := `{"type": "FeatureCollection", "features": [` +
+		feature(`"A"`, square("0.5", "0.5", "2.5", "2.5")) + `, ` +
+		feature(`7`, square("-0.5", "0.5", "1.5", "2.5")) + `, ` +
+		feature(`"unscored"`, square("-1", "-1", "5", "5")) + `]}`
+
+	scored := func(value uint8) color.RGBA { return color.RGBA{G: value, A: 255} }
+	outside := color.RGBA{}
+
+	tests := []struct {
+		name         string
+		defaultScore *float64
+		want         [][]color.RGBA
+	}{
+		{
+			"uncovered pixels have no data",
+			nil,
+			[][]color.RGBA{
+				{scored(127), scored(255), scored(255), noDataColor},
+				{scored(127), scored(255), scored(255), outside},
+			},
+		},
+		{
+			"uncovered pixels take the default score",
+			ptr(0.2),
+			[][]color.RGBA{
+				{scored(127), scored(255), scored(255), scored(51)},
+				{scored(127), scored(255), scored(255), outside},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		data := SubmitZoneMaskData{
+			CategoryProperty: "zone",
+			CategoryScores:   map[string]float64{"A": 1, "7": 0.5},
+			DefaultScore:     test.defaultScore,
+		}
+
+		img, err := submitZoneMask(strings.NewReader(geoJson), data)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+
+		for y, row := range test.want {
+			for x, want := range row {
+				if got := img.RGBAAt(x, y); got != want {
+					t.Errorf("%s: pixel %d, %d = %v, want %v", test.name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestSubmitZoneMaskErrors(t *testing.T) {
+	polygon := `{"type": "Polygon", "coordinates": [[[0, 0], [1, 0], [1, 1], [0, 0]]]}`
+	collection := func(properties, geometry string) string {
+		return `{"type": "FeatureCollection", "features": [{"type": "Feature", "properties": ` + properties + `, "geometry": ` + geometry + `}]}`
+	}
+
+	tests := []struct {
+		name    string
+		geoJson string
+		data    SubmitZoneMaskData
+		wantErr string
+	}{
+		{
+			"score above 1",
+			collection(`{"zone": "A"}`, polygon),
+			SubmitZoneMaskData{CategoryProperty: "zone", CategoryScores: map[string]float64{"A": 2}},
+			"between 0 and 1",
+		},
+		{
+			"default score below 0",
+			collection(`{"zone": "A"}`, polygon),
+			SubmitZoneMaskData{CategoryProperty: "zone", CategoryScores: map[string]float64{"A": 1}, DefaultScore: ptr(-0.5)},
+			"between 0 and 1",
+		},
+		{
+			"missing category property",
+			collection(`{"other": "A"}`, polygon),
+			SubmitZoneMaskData{CategoryProperty: "zone", CategoryScores: map[string]float64{"A": 1}},
+			"could not find category property",
+		},
+		{
+			"not a polygon",
+			collection(`{"zone": "A"}`, `{"type": "Point", "coordinates": [0, 0]}`),
+			SubmitZoneMaskData{CategoryProperty: "zone", CategoryScores: map[string]float64{"A": 1}},
+			"Polygon",
+		},
+	}
+
+	for _, test := range tests {
+		if _, err := submitZoneMask(strings.NewReader(test.geoJson), test.data); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+			t.Errorf("%s: error = %v, want one containing %q", test.name, err, test.wantErr)
+		}
+	}
+}